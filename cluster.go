@@ -0,0 +1,300 @@
+package main
+
+import (
+	"fmt"
+	"net"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/prometheus/client_golang/prometheus"
+)
+
+// clusterNode is a node found via discovery.
+type clusterNode struct {
+	id      string
+	addr    string
+	tlsName string // from the peers list, if we use TLS
+}
+
+// cluster collects from every node in the cluster the seed node is part of.
+// All metrics get a "node" label with the node ID. Nodes are rediscovered on
+// every scrape, and all nodes are collected concurrently.
+type cluster struct {
+	seeds      []*connPool
+	dialer     *dialer
+	collectors []string
+	opts       collectorOpts
+
+	mu    sync.Mutex
+	nodes map[clusterNode]*asCollector
+	pools map[string]*connPool // per node address, shared by discovery and the collectors
+}
+
+// newCluster makes a cluster. Seeds are tried in order.
+func newCluster(seeds []string, d *dialer, collectors []string, opts collectorOpts) *cluster {
+	cl := &cluster{
+		dialer:     d,
+		collectors: collectors,
+		opts:       opts,
+		nodes:      map[clusterNode]*asCollector{},
+		pools:      map[string]*connPool{},
+	}
+	for _, s := range seeds {
+		cl.seeds = append(cl.seeds, newConnPool(s, d, 1))
+	}
+	return cl
+}
+
+// register is a registerFunc for all nodes in the cluster.
+func (cl *cluster) register(reg prometheus.Registerer, deadline time.Time) error {
+	nodes, err := cl.discover(deadline)
+	if err != nil {
+		return err
+	}
+
+	cl.mu.Lock()
+	defer cl.mu.Unlock()
+	var (
+		seen     = map[clusterNode]bool{}
+		seenAddr = map[string]bool{}
+	)
+	for _, n := range nodes {
+		seen[n] = true
+		seenAddr[n.addr] = true
+	}
+	// forget nodes which left the cluster, or which have a new ID or
+	// tls-name, before their address is used again
+	for n, col := range cl.nodes {
+		if !seen[n] {
+			col.close()
+			delete(cl.nodes, n)
+			delete(cl.pools, n.addr)
+		}
+	}
+	for addr, p := range cl.pools {
+		if !seenAddr[addr] {
+			p.close()
+			delete(cl.pools, addr)
+		}
+	}
+	for _, n := range nodes {
+		col, ok := cl.nodes[n]
+		if !ok {
+			col = newAsCollector(n.addr, cl.dialer, cl.collectors, cl.opts)
+			col.pool = cl.pool(n.addr)
+			col.pool.tlsName = n.tlsName
+			cl.nodes[n] = col
+		}
+		if err := prometheus.WrapRegistererWith(
+			prometheus.Labels{"node": n.id},
+			reg,
+		).Register(deadlineCollector{col, deadline}); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// pool returns the connection pool for a node address. cl.mu must be held.
+func (cl *cluster) pool(addr string) *connPool {
+	p, ok := cl.pools[addr]
+	if !ok {
+		p = newConnPool(addr, cl.dialer, len(cl.collectors))
+		cl.pools[addr] = p
+	}
+	return p
+}
+
+// close closes all idle connections.
+func (cl *cluster) close() {
+	for _, s := range cl.seeds {
+		s.close()
+	}
+	cl.mu.Lock()
+	defer cl.mu.Unlock()
+	for _, col := range cl.nodes {
+		col.close()
+	}
+	for _, p := range cl.pools {
+		p.close()
+	}
+}
+
+// discover uses the first seed which works.
+func (cl *cluster) discover(deadline time.Time) ([]clusterNode, error) {
+	var errs []string
+	for _, s := range cl.seeds {
+		if time.Now().After(deadline) {
+			errs = append(errs, errDeadline.Error())
+			break
+		}
+		nodes, err := cl.discoverVia(s, deadline)
+		if err == nil {
+			return nodes, nil
+		}
+		errs = append(errs, fmt.Sprintf("discovery via %s: %s", s.addr, err))
+	}
+	return nil, fmt.Errorf("%s", strings.Join(errs, "; "))
+}
+
+// discoverVia returns the seed node and all its peers.
+func (cl *cluster) discoverVia(seed *connPool, deadline time.Time) ([]clusterNode, error) {
+	conn, err := seed.getUntil(deadline)
+	if err != nil {
+		return nil, err
+	}
+	info, err := cl.requestPeers(connClient{conn})
+	seed.put(conn, err)
+	if err != nil {
+		return nil, err
+	}
+	nodes := []clusterNode{{id: info["node"], addr: seed.addr}}
+
+	if peers, ok := info[cl.peersCmd()]; ok {
+		ps, err := parsePeers(peers)
+		if err != nil {
+			return nil, err
+		}
+		return append(nodes, ps...), nil
+	}
+
+	// older servers don't have the peers commands, and their services list
+	// doesn't contain node IDs. The connections of the nodes are kept, so we
+	// don't dial every node on every scrape.
+	for _, addr := range parseServices(info["services"]) {
+		cl.mu.Lock()
+		p := cl.pool(addr)
+		cl.mu.Unlock()
+		id, err := nodeID(p, deadline)
+		if err != nil {
+			return nil, fmt.Errorf("node %s: %s", addr, err)
+		}
+		nodes = append(nodes, clusterNode{id: id, addr: addr})
+	}
+	return nodes, nil
+}
+
+// requestPeers asks the seed for its node ID, and its peers. The services
+// list is only asked for when the node doesn't know the peers command.
+func (cl *cluster) requestPeers(c infoClient) (map[string]string, error) {
+	peersCmd := cl.peersCmd()
+	info, err := c.RequestInfo("node", peersCmd)
+	if err != nil {
+		return nil, err
+	}
+	if peers, ok := info[peersCmd]; ok && !strings.HasPrefix(peers, "ERROR") {
+		return info, nil
+	}
+	delete(info, peersCmd)
+	services, err := c.RequestInfo("services")
+	if err != nil {
+		return nil, err
+	}
+	info["services"] = services["services"]
+	return info, nil
+}
+
+// peersCmd is the info command for the peers list. With TLS we need the TLS
+// ports, and the tls-name of every peer.
+func (cl *cluster) peersCmd() string {
+	if cl.dialer.tls != nil {
+		return "peers-tls-std"
+	}
+	return "peers-clear-std"
+}
+
+// nodeID asks a node for its ID.
+func nodeID(p *connPool, deadline time.Time) (_ string, err error) {
+	conn, err := p.getUntil(deadline)
+	if err != nil {
+		return "", err
+	}
+	defer func() { p.put(conn, err) }()
+
+	info, err := (connClient{conn}).RequestInfo("node")
+	if err != nil {
+		return "", err
+	}
+	return info["node"], nil
+}
+
+// parsePeers parses the output of the peers-clear-std and peers-tls-std
+// commands, which looks like:
+//
+//	10,3000,[[BB9020011AC4202,,[172.17.0.4]],[BB9030011AC4202,,[172.17.0.3:3100]]]
+//
+// The first field is the peers generation, the second the default port.
+// Every node has its ID, its tls-name (empty for clear text), and its
+// addresses. Only the first address of every node is used.
+func parsePeers(s string) ([]clusterNode, error) {
+	fields := splitPeers(s)
+	if len(fields) != 3 {
+		return nil, fmt.Errorf("invalid peers format: %q", s)
+	}
+	port := fields[1]
+
+	var nodes []clusterNode
+	for _, p := range splitPeers(unbracket(fields[2])) {
+		pfs := splitPeers(unbracket(p))
+		if len(pfs) != 3 {
+			return nil, fmt.Errorf("invalid peer format: %q", p)
+		}
+		addrs := splitPeers(unbracket(pfs[2]))
+		if len(addrs) == 0 || addrs[0] == "" {
+			return nil, fmt.Errorf("peer without address: %q", p)
+		}
+		addr := addrs[0]
+		if _, _, err := net.SplitHostPort(addr); err != nil {
+			addr = net.JoinHostPort(unbracket(addr), port)
+		}
+		nodes = append(nodes, clusterNode{id: pfs[0], addr: addr, tlsName: pfs[1]})
+	}
+	return nodes, nil
+}
+
+// splitPeers splits on commas which are not inside brackets.
+func splitPeers(s string) []string {
+	if s == "" {
+		return nil
+	}
+	var (
+		res   []string
+		depth = 0
+		start = 0
+	)
+	for i, c := range s {
+		switch c {
+		case '[':
+			depth++
+		case ']':
+			depth--
+		case ',':
+			if depth == 0 {
+				res = append(res, s[start:i])
+				start = i + 1
+			}
+		}
+	}
+	return append(res, s[start:])
+}
+
+func unbracket(s string) string {
+	if strings.HasPrefix(s, "[") && strings.HasSuffix(s, "]") {
+		return s[1 : len(s)-1]
+	}
+	return s
+}
+
+// parseServices parses the output of the services command:
+//
+//	172.17.0.3:3000;172.17.0.4:3000
+func parseServices(s string) []string {
+	var addrs []string
+	for _, a := range strings.Split(s, ";") {
+		if a != "" {
+			addrs = append(addrs, a)
+		}
+	}
+	return addrs
+}
@@ -0,0 +1,201 @@
+package main
+
+import (
+	"path/filepath"
+	"reflect"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/prometheus/client_golang/prometheus"
+	dto "github.com/prometheus/client_model/go"
+
+	"github.com/alicebob/asprom/internal/fakeaero"
+)
+
+func TestParsePeers(t *testing.T) {
+	type cas struct {
+		peers string
+		error string
+		want  []clusterNode
+	}
+	for _, c := range []cas{
+		{
+			peers: "10,3000,[[BB9020011AC4202,,[172.17.0.4]],[BB9030011AC4202,,[172.17.0.3:3100,10.0.0.3]]]",
+			want: []clusterNode{
+				{id: "BB9020011AC4202", addr: "172.17.0.4:3000"},
+				{id: "BB9030011AC4202", addr: "172.17.0.3:3100"},
+			},
+		},
+		{
+			peers: "2,3000,[[BB9020011AC4202,,[2001:db8::1]],[BB9030011AC4202,aero.example.com,[[2001:db8::2]:3100]]]",
+			want: []clusterNode{
+				{id: "BB9020011AC4202", addr: "[2001:db8::1]:3000"},
+				{id: "BB9030011AC4202", addr: "[2001:db8::2]:3100", tlsName: "aero.example.com"},
+			},
+		},
+		{
+			// peers-tls-std
+			peers: "4,4333,[[BB9020011AC4202,aero1.example.com,[172.17.0.4]],[BB9030011AC4202,aero2.example.com,[172.17.0.3:4334]]]",
+			want: []clusterNode{
+				{id: "BB9020011AC4202", addr: "172.17.0.4:4333", tlsName: "aero1.example.com"},
+				{id: "BB9030011AC4202", addr: "172.17.0.3:4334", tlsName: "aero2.example.com"},
+			},
+		},
+		{
+			peers: "0,3000,[]",
+		},
+		{
+			peers: "10,3000,[[BB9020011AC4202,,[]]]",
+			error: `peer without address: "[BB9020011AC4202,,[]]"`,
+		},
+		{
+			peers: "ERROR::not supported",
+			error: `invalid peers format: "ERROR::not supported"`,
+		},
+	} {
+		res, err := parsePeers(c.peers)
+		haveerr := ""
+		if err != nil {
+			haveerr = err.Error()
+		}
+		if have, want := haveerr, c.error; have != want {
+			t.Errorf("have %q, want %q", have, want)
+			continue
+		}
+		if have, want := res, c.want; !reflect.DeepEqual(have, want) {
+			t.Errorf("have %+v, want %+v", have, want)
+		}
+	}
+}
+
+func TestParseServices(t *testing.T) {
+	for k, v := range map[string][]string{
+		"":                                nil,
+		"172.17.0.3:3000":                 {"172.17.0.3:3000"},
+		"172.17.0.3:3000;172.17.0.4:3000": {"172.17.0.3:3000", "172.17.0.4:3000"},
+	} {
+		if have, want := parseServices(k), v; !reflect.DeepEqual(have, want) {
+			t.Errorf("have %+v, want %+v", have, want)
+		}
+	}
+}
+
+func TestClusterRegister(t *testing.T) {
+	// newNode starts a fake node with a fixture
+	newNode := func(t *testing.T, version string) *fakeaero.Server {
+		s, err := fakeaero.New()
+		if err != nil {
+			t.Fatal(err)
+		}
+		if err := s.Load(filepath.Join("testdata", version, "asinfo.txt")); err != nil {
+			t.Fatal(err)
+		}
+		return s
+	}
+
+	type cas struct {
+		name  string
+		setup func(seed, peer *fakeaero.Server)
+		error string
+		want  []string
+	}
+	for _, c := range []cas{
+		{
+			name: "peers",
+			setup: func(seed, peer *fakeaero.Server) {
+				seed.SetResponses(map[string]string{
+					"peers-clear-std": "1,3000,[[BB9020011AC4202,,[" + peer.Addr() + "]]]",
+				})
+			},
+			want: []string{
+				`aerospike_node_up{node="BB9030011AC4202"} 1`,
+				`aerospike_node_up{node="BB9020011AC4202"} 1`,
+			},
+		},
+		{
+			name: "services",
+			setup: func(seed, peer *fakeaero.Server) {
+				seed.SetResponses(map[string]string{
+					"services": peer.Addr(),
+				})
+			},
+			want: []string{
+				`aerospike_node_up{node="BB9030011AC4202"} 1`,
+				`aerospike_node_up{node="BB9020011AC4202"} 1`,
+			},
+		},
+		{
+			name:  "disconnects",
+			setup: func(seed, peer *fakeaero.Server) { seed.SetDisconnect(true) },
+			error: "discovery via ",
+		},
+	} {
+		t.Run(c.name, func(t *testing.T) {
+			seed := newNode(t, "4.9")
+			defer seed.Close()
+			peer := newNode(t, "3.15")
+			defer peer.Close()
+			c.setup(seed, peer)
+
+			cl := newCluster([]string{seed.Addr()}, newDialer("", "", nil, "", false), []string{"stats"}, collectorOpts{})
+			defer cl.close()
+			scrape := func() ([]*dto.MetricFamily, error) {
+				reg := prometheus.NewRegistry()
+				if err := cl.register(reg, time.Now().Add(time.Second)); err != nil {
+					return nil, err
+				}
+				return reg.Gather()
+			}
+			mfs, err := scrape()
+			haveerr := ""
+			if err != nil {
+				haveerr = err.Error()
+			}
+			if c.error != "" {
+				if !strings.HasPrefix(haveerr, c.error) {
+					t.Errorf("have %q, want %q", haveerr, c.error)
+				}
+				if have, want := len(cl.seeds[0].idle), 0; have != want {
+					t.Errorf("have %d idle seed connections, want %d", have, want)
+				}
+				return
+			}
+			if err != nil {
+				t.Fatal(err)
+			}
+			have := exposition(t, mfs)
+			for _, want := range c.want {
+				if !strings.Contains(have, want+"\n") {
+					t.Errorf("no %q in:\n%s", want, have)
+				}
+			}
+
+			// the next scrape reuses the connections
+			dialed := seed.Accepted() + peer.Accepted()
+			if _, err := scrape(); err != nil {
+				t.Fatal(err)
+			}
+			if have, want := seed.Accepted()+peer.Accepted(), dialed; have != want {
+				t.Errorf("have %d connections, want %d", have, want)
+			}
+		})
+	}
+}
+
+func TestClusterDeadline(t *testing.T) {
+	s, err := fakeaero.New()
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer s.Close()
+	cl := newCluster([]string{s.Addr()}, newDialer("", "", nil, "", false), []string{"stats"}, collectorOpts{})
+	defer cl.close()
+	err = cl.register(prometheus.NewRegistry(), time.Now().Add(-time.Second))
+	if have, want := err, errDeadline; have == nil || have.Error() != want.Error() {
+		t.Errorf("have %v, want %v", have, want)
+	}
+	if have, want := s.Accepted(), 0; have != want {
+		t.Errorf("have %d connections, want %d", have, want)
+	}
+}
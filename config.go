@@ -0,0 +1,282 @@
+package main
+
+import (
+	"fmt"
+	"io/ioutil"
+	"regexp"
+	"sort"
+	"strings"
+
+	"github.com/prometheus/client_golang/prometheus"
+	"gopkg.in/yaml.v2"
+)
+
+var (
+	labelNameRE = regexp.MustCompile("^[a-zA-Z_][a-zA-Z0-9_]*$")
+	valueTypes  = map[string]prometheus.ValueType{
+		"counter": prometheus.CounterValue,
+		"gauge":   prometheus.GaugeValue,
+		"untyped": prometheus.UntypedValue,
+	}
+	// reservedLabels are the label names collectors use. A constant label
+	// with the same name would make the metrics invalid.
+	reservedLabels = map[string]bool{
+		"bin":          true,
+		"build":        true,
+		"cluster_name": true,
+		"collector":    true,
+		"dc":           true,
+		"edition":      true,
+		"features":     true,
+		"index":        true,
+		"indextype":    true,
+		"le":           true,
+		"mount":        true,
+		"namespace":    true,
+		"node":         true,
+		"node_id":      true,
+		"op":           true,
+		"path":         true,
+		"principal":    true,
+		"quantile":     true,
+		"rack":         true,
+		"service":      true,
+		"set":          true,
+		"sindex":       true,
+		"threshold":    true,
+		"type":         true,
+	}
+)
+
+// config is everything which can be configured in the -config YAML file.
+// Without a config file it's made from the flags.
+type config struct {
+	Listen string `yaml:"listen"`
+	// Nodes to collect from. Multiple nodes are only allowed with discover,
+	// they are then tried in order as seed.
+	Nodes        []string `yaml:"nodes"`
+	Discover     bool     `yaml:"discover"`
+	AllowTargets []string `yaml:"allow_targets"`
+	Username     string   `yaml:"username"`
+	UsernameFile string   `yaml:"username_file"`
+	Password     string   `yaml:"password"`
+	PasswordFile string   `yaml:"password_file"`
+	TLS          struct {
+		Enabled    bool   `yaml:"enabled"`
+		CAFile     string `yaml:"ca_file"`
+		CertFile   string `yaml:"cert_file"`
+		KeyFile    string `yaml:"key_file"`
+		Name       string `yaml:"name"`
+		MinVersion string `yaml:"min_version"`
+		PKI        bool   `yaml:"pki"`
+	} `yaml:"tls"`
+	// ClusterSize is the expected number of nodes, for cluster-stable:.
+	// 0 doesn't check the size.
+	ClusterSize int `yaml:"cluster_size"`
+	// Collectors to run. Empty means all.
+	Collectors []string `yaml:"collectors"`
+	Metrics    struct {
+		// by metric name
+		patternConfig `yaml:",inline"`
+		// by label value
+		Namespaces patternConfig `yaml:"namespaces"`
+		Sets       patternConfig `yaml:"sets"`
+		Sindexes   patternConfig `yaml:"sindexes"`
+		DCs        patternConfig `yaml:"dcs"`
+		// AutoExport exports every numeric statistic.
+		AutoExport bool `yaml:"auto_export"`
+		// Types of auto exported metrics, by metric name: counter, gauge,
+		// or untyped (the default).
+		Types map[string]string `yaml:"types"`
+	} `yaml:"metrics"`
+	// Labels are added to every metric.
+	Labels map[string]string `yaml:"labels"`
+}
+
+// patternConfig has regular expressions for a nameFilter.
+type patternConfig struct {
+	Include []string `yaml:"include"`
+	Exclude []string `yaml:"exclude"`
+}
+
+func (pc patternConfig) filter() (*nameFilter, error) {
+	return newNameFilter(pc.Include, pc.Exclude)
+}
+
+// loadConfig reads and validates a config file.
+func loadConfig(filename string) (*config, error) {
+	b, err := ioutil.ReadFile(filename)
+	if err != nil {
+		return nil, err
+	}
+	cfg := &config{}
+	if err := yaml.UnmarshalStrict(b, cfg); err != nil {
+		return nil, fmt.Errorf("%s: %s", filename, err)
+	}
+	if err := cfg.validate(); err != nil {
+		return nil, fmt.Errorf("%s: %s", filename, err)
+	}
+	return cfg, nil
+}
+
+// validate checks the config, and reads the credential files.
+func (cfg *config) validate() error {
+	if cfg.Listen == "" {
+		return fmt.Errorf("listen: missing")
+	}
+	if len(cfg.Nodes) == 0 {
+		return fmt.Errorf("nodes: missing")
+	}
+	if len(cfg.Nodes) > 1 && !cfg.Discover {
+		return fmt.Errorf("nodes: multiple nodes need discover: true")
+	}
+
+	if cfg.UsernameFile != "" {
+		if cfg.Username != "" {
+			return fmt.Errorf("username_file: can't use both username and username_file")
+		}
+		u, err := readSecret(cfg.UsernameFile)
+		if err != nil {
+			return fmt.Errorf("username_file: %s", err)
+		}
+		cfg.Username = u
+		cfg.UsernameFile = ""
+	}
+	if cfg.PasswordFile != "" {
+		if cfg.Password != "" {
+			return fmt.Errorf("password_file: can't use both password and password_file")
+		}
+		p, err := readSecret(cfg.PasswordFile)
+		if err != nil {
+			return fmt.Errorf("password_file: %s", err)
+		}
+		cfg.Password = p
+		cfg.PasswordFile = ""
+	}
+
+	tlsConfig, err := cfg.tlsOptions().config()
+	if err != nil {
+		return fmt.Errorf("tls: %s", err)
+	}
+	if tlsConfig == nil && cfg.TLS.Name != "" {
+		return fmt.Errorf("tls: name without TLS")
+	}
+	if cfg.TLS.PKI && cfg.TLS.CertFile == "" {
+		return fmt.Errorf("tls: pki needs a cert_file")
+	}
+	if cfg.ClusterSize < 0 {
+		return fmt.Errorf("cluster_size: can't be negative")
+	}
+	if (cfg.Username != "" || cfg.TLS.PKI) && len(cfg.AllowTargets) == 0 {
+		// /probe would send the credentials to any host
+		return fmt.Errorf("allow_targets: needed with username or pki")
+	}
+
+	for _, c := range cfg.Collectors {
+		if _, ok := allCollectors[c]; !ok {
+			return fmt.Errorf("collectors: unknown collector %q. Valid collectors: %s", c, strings.Join(collectorNames(), ", "))
+		}
+	}
+
+	if _, err := cfg.collectorOpts(); err != nil {
+		return err
+	}
+
+	for k := range cfg.Labels {
+		if !labelNameRE.MatchString(k) {
+			return fmt.Errorf("labels: invalid label name %q", k)
+		}
+		if reservedLabels[k] {
+			return fmt.Errorf("labels: label name %q is used by asprom", k)
+		}
+	}
+	return nil
+}
+
+func (cfg *config) tlsOptions() tlsOptions {
+	return tlsOptions{
+		enabled:    cfg.TLS.Enabled,
+		caFile:     cfg.TLS.CAFile,
+		certFile:   cfg.TLS.CertFile,
+		keyFile:    cfg.TLS.KeyFile,
+		minVersion: cfg.TLS.MinVersion,
+	}
+}
+
+// collectors returns the enabled collector names.
+func (cfg *config) collectors() []string {
+	if len(cfg.Collectors) == 0 {
+		return collectorNames()
+	}
+	return cfg.Collectors
+}
+
+func (cfg *config) collectorOpts() (collectorOpts, error) {
+	opts := collectorOpts{
+		autoExport:  cfg.Metrics.AutoExport,
+		clusterSize: cfg.ClusterSize,
+		types:       map[string]prometheus.ValueType{},
+		labels:      map[string]bool{},
+	}
+	for k := range cfg.Labels {
+		opts.labels[k] = true
+	}
+	for name, t := range cfg.Metrics.Types {
+		vt, ok := valueTypes[t]
+		if !ok {
+			return opts, fmt.Errorf("metrics: types: %s: unknown type %q", name, t)
+		}
+		opts.types[name] = vt
+	}
+
+	var err error
+	for _, f := range []struct {
+		name   string
+		config patternConfig
+		filter **nameFilter
+	}{
+		{"metrics", cfg.Metrics.patternConfig, &opts.metrics},
+		{"metrics: namespaces", cfg.Metrics.Namespaces, &opts.namespaces},
+		{"metrics: sets", cfg.Metrics.Sets, &opts.sets},
+		{"metrics: sindexes", cfg.Metrics.Sindexes, &opts.sindexes},
+		{"metrics: dcs", cfg.Metrics.DCs, &opts.dcs},
+	} {
+		if *f.filter, err = f.config.filter(); err != nil {
+			return opts, fmt.Errorf("%s: %s", f.name, err)
+		}
+	}
+	return opts, nil
+}
+
+func (cfg *config) labels() prometheus.Labels {
+	return prometheus.Labels(cfg.Labels)
+}
+
+func readSecret(filename string) (string, error) {
+	b, err := ioutil.ReadFile(filename)
+	if err != nil {
+		return "", err
+	}
+	return strings.TrimSpace(string(b)), nil
+}
+
+func compilePatterns(ps []string) ([]*regexp.Regexp, error) {
+	var res []*regexp.Regexp
+	for _, p := range ps {
+		re, err := regexp.Compile("^(?:" + p + ")$")
+		if err != nil {
+			return nil, err
+		}
+		res = append(res, re)
+	}
+	return res, nil
+}
+
+func collectorNames() []string {
+	var names []string
+	for n := range allCollectors {
+		names = append(names, n)
+	}
+	sort.Strings(names)
+	return names
+}
@@ -0,0 +1,242 @@
+package main
+
+import (
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"reflect"
+	"testing"
+)
+
+func TestLoadConfig(t *testing.T) {
+	dir, err := ioutil.TempDir("", "asprom")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.RemoveAll(dir)
+	pwFile := filepath.Join(dir, "password")
+	if err := ioutil.WriteFile(pwFile, []byte("s3cret\n"), 0600); err != nil {
+		t.Fatal(err)
+	}
+
+	type cas struct {
+		yaml  string
+		error string
+	}
+	for n, c := range []cas{
+		{
+			yaml: `
+listen: ":9145"
+nodes: ["10.0.0.1:3000", "10.0.0.2:3000"]
+discover: true
+cluster_size: 2
+allow_targets: ["10.0.0.1:3000", "10.0.0.2:3000"]
+username: admin
+password_file: ` + pwFile + `
+tls:
+  enabled: true
+  name: aerospike-cluster
+  min_version: "1.2"
+collectors: [namespace, stats]
+metrics:
+  exclude: ["aerospike_set_.*"]
+labels:
+  region: eu1
+`,
+		},
+		{
+			yaml:  `listen: ":9145"`,
+			error: "nodes: missing",
+		},
+		{
+			yaml: `
+listen: ":9145"
+nodes: ["10.0.0.1:3000", "10.0.0.2:3000"]
+`,
+			error: "nodes: multiple nodes need discover: true",
+		},
+		{
+			yaml: `
+listen: ":9145"
+nodes: ["10.0.0.1:3000"]
+collectors: [namespace, foo]
+`,
+			error: `collectors: unknown collector "foo". Valid collectors: cluster, config, histogram, latency, namespace, nodeinfo, roster, set, sindex, stats, xdr`,
+		},
+		{
+			yaml: `
+listen: ":9145"
+nodes: ["10.0.0.1:3000"]
+metrics:
+  include: ["aerospike_(ns"]
+`,
+			error: "metrics: include: error parsing regexp: missing closing ): `^(?:aerospike_(ns)$`",
+		},
+		{
+			yaml: `
+listen: ":9145"
+nodes: ["10.0.0.1:3000"]
+metrics:
+  namespaces:
+    exclude: ["temp_[a-z"]
+`,
+			error: "metrics: namespaces: exclude: error parsing regexp: missing closing ]: `[a-z)$`",
+		},
+		{
+			yaml: `
+listen: ":9145"
+nodes: ["10.0.0.1:3000"]
+metrics:
+  types:
+    aerospike_ns_foo: histogram
+`,
+			error: `metrics: types: aerospike_ns_foo: unknown type "histogram"`,
+		},
+		{
+			yaml: `
+listen: ":9145"
+nodes: ["10.0.0.1:3000"]
+labels:
+  "data-center": eu1
+`,
+			error: `labels: invalid label name "data-center"`,
+		},
+		{
+			yaml: `
+listen: ":9145"
+nodes: ["10.0.0.1:3000"]
+labels:
+  dc: eu1
+`,
+			error: `labels: label name "dc" is used by asprom`,
+		},
+		{
+			yaml: `
+listen: ":9145"
+nodes: ["10.0.0.1:3000"]
+password_file: /no/such/file
+`,
+			error: "password_file: open /no/such/file: no such file or directory",
+		},
+		{
+			yaml: `
+listen: ":9145"
+nodes: ["10.0.0.1:3000"]
+tls:
+  ca_file: /no/such/ca.pem
+`,
+			error: "tls: open /no/such/ca.pem: no such file or directory",
+		},
+		{
+			yaml: `
+listen: ":9145"
+nodes: ["10.0.0.1:3000"]
+tls:
+  name: aerospike-cluster
+`,
+			error: "tls: name without TLS",
+		},
+		{
+			yaml: `
+listen: ":9145"
+nodes: ["10.0.0.1:3000"]
+tls:
+  min_version: "1.2"
+`,
+			error: "tls: min_version without TLS",
+		},
+		{
+			yaml: `
+listen: ":9145"
+nodes: ["10.0.0.1:3000"]
+cluster_size: -1
+`,
+			error: "cluster_size: can't be negative",
+		},
+		{
+			yaml: `
+listen: ":9145"
+nodes: ["10.0.0.1:3000"]
+username: admin
+password: s3cret
+`,
+			error: "allow_targets: needed with username or pki",
+		},
+		{
+			yaml: `
+listen: ":9145"
+node: "10.0.0.1:3000"
+`,
+			error: "yaml: unmarshal errors:\n  line 3: field node not found in type main.config",
+		},
+	} {
+		filename := filepath.Join(dir, "asprom.yml")
+		if err := ioutil.WriteFile(filename, []byte(c.yaml), 0600); err != nil {
+			t.Fatal(err)
+		}
+		cfg, err := loadConfig(filename)
+		haveerr := ""
+		if err != nil {
+			haveerr = err.Error()
+		}
+		if c.error != "" {
+			if have, want := haveerr, filename+": "+c.error; have != want {
+				t.Errorf("case %d: have %q, want %q", n, have, want)
+			}
+			continue
+		}
+		if haveerr != "" {
+			t.Fatalf("case %d: %s", n, haveerr)
+		}
+		if have, want := cfg.Password, "s3cret"; have != want {
+			t.Errorf("have %q, want %q", have, want)
+		}
+		if have, want := cfg.collectors(), []string{"namespace", "stats"}; !reflect.DeepEqual(have, want) {
+			t.Errorf("have %+v, want %+v", have, want)
+		}
+	}
+}
+
+func TestNameFilter(t *testing.T) {
+	f, err := newNameFilter(
+		[]string{"aerospike_ns_.*", "aerospike_set_objects"},
+		[]string{"aerospike_ns_client_.*"},
+	)
+	if err != nil {
+		t.Fatal(err)
+	}
+	for name, want := range map[string]bool{
+		"aerospike_ns_objects":            true,
+		"aerospike_ns_client_read_errors": false,
+		"aerospike_set_objects":           true,
+		"aerospike_set_objects_total":     false,
+		"aerospike_node_up":               false,
+	} {
+		if have := f.allow(name); have != want {
+			t.Errorf("%s: have %t, want %t", name, have, want)
+		}
+	}
+}
+
+func TestCollectorFilters(t *testing.T) {
+	cfg := &config{}
+	cfg.Metrics.Exclude = []string{"aerospike_set_.*_bytes"}
+	cfg.Metrics.Sets.Exclude = []string{"temp_.*"}
+	opts, err := cfg.collectorOpts()
+	if err != nil {
+		t.Fatal(err)
+	}
+	sc := newSetCollector(opts)
+	if _, ok := sc.metrics["memory_data_bytes"]; ok {
+		t.Errorf("memory_data_bytes should have been filtered")
+	}
+	if _, ok := sc.metrics["objects"]; !ok {
+		t.Errorf("objects should not have been filtered")
+	}
+	if !sc.sets.allow("users") || sc.sets.allow("temp_users") {
+		t.Errorf("set filter is wrong")
+	}
+	if !sc.namespaces.allow("anything") {
+		t.Errorf("empty filter should allow everything")
+	}
+}
@@ -0,0 +1,273 @@
+package main
+
+import (
+	"crypto/tls"
+	"encoding/binary"
+	"fmt"
+	"net"
+	"strconv"
+	"sync"
+	"time"
+
+	as "github.com/aerospike/aerospike-client-go"
+)
+
+const (
+	dialTimeout = 3 * time.Second
+	// Aerospike closes client connections which are idle for longer than
+	// proto-fd-idle-ms, which defaults to 60s.
+	maxIdleTime = 50 * time.Second
+	// connections which were used recently are trusted without a ping
+	pingAfter = 5 * time.Second
+)
+
+// dialer connects and authenticates to Aerospike nodes. The bcrypt hash of the
+// password is computed only once, since it's slow on purpose.
+type dialer struct {
+	username string
+	password string
+	tls      *tls.Config // nil for plain text connections
+	tlsName  string      // the node's tls-name. Defaults to the host.
+	pki      bool        // authenticate with the TLS client certificate
+
+	hashOnce sync.Once
+	hashed   []byte
+	hashErr  error
+}
+
+func newDialer(username, password string, tlsConfig *tls.Config, tlsName string, pki bool) *dialer {
+	return &dialer{
+		username: username,
+		password: password,
+		tls:      tlsConfig,
+		tlsName:  tlsName,
+		pki:      pki,
+	}
+}
+
+// dial connects to a node, and authenticates if there is a username, or with
+// PKI. tlsName is the node's tls-name, if it's not the dialer's.
+func (d *dialer) dial(addr, tlsName string) (*as.Connection, error) {
+	conn, err := d.connect(addr, tlsName)
+	if err != nil {
+		return nil, err
+	}
+
+	if d.pki {
+		if err := pkiLogin(conn); err != nil {
+			conn.Close()
+			return nil, fmt.Errorf("PKI auth error: %s", err)
+		}
+		return conn, nil
+	}
+
+	if d.username != "" {
+		hp, err := d.hashedPassword()
+		if err != nil {
+			conn.Close()
+			return nil, fmt.Errorf("hashPassword: %s", err)
+		}
+		if err := conn.Authenticate(d.username, hp); err != nil {
+			return nil, fmt.Errorf("auth error: %s", err)
+		}
+	}
+	return conn, nil
+}
+
+func (d *dialer) connect(addr, tlsName string) (*as.Connection, error) {
+	if d.tls == nil {
+		return as.NewConnection(addr, dialTimeout)
+	}
+
+	host, ps, err := net.SplitHostPort(addr)
+	if err != nil {
+		return nil, err
+	}
+	port, err := strconv.Atoi(ps)
+	if err != nil {
+		return nil, fmt.Errorf("invalid port in %q", addr)
+	}
+	h := as.NewHost(host, port)
+	h.TLSName = tlsName
+	if h.TLSName == "" {
+		h.TLSName = d.tlsName
+	}
+	if h.TLSName == "" {
+		h.TLSName = host
+	}
+	policy := as.NewClientPolicy()
+	policy.Timeout = dialTimeout
+	policy.TlsConfig = d.tls
+	return as.NewSecureConnection(policy, h)
+}
+
+func (d *dialer) hashedPassword() ([]byte, error) {
+	d.hashOnce.Do(func() {
+		d.hashed, d.hashErr = hashPassword(d.password)
+	})
+	return d.hashed, d.hashErr
+}
+
+// connPool keeps authenticated connections to a single node around between
+// scrapes.
+type connPool struct {
+	addr    string
+	tlsName string // overrides the dialer's tls-name, if set
+	dialer  *dialer
+	maxIdle int
+
+	mu     sync.Mutex
+	idle   []idleConn
+	closed bool
+}
+
+type idleConn struct {
+	conn  *as.Connection
+	since time.Time
+}
+
+func newConnPool(addr string, d *dialer, maxIdle int) *connPool {
+	return &connPool{
+		addr:    addr,
+		dialer:  d,
+		maxIdle: maxIdle,
+	}
+}
+
+// get returns a healthy idle connection, or dials a new one.
+func (p *connPool) get() (*as.Connection, error) {
+	for {
+		p.mu.Lock()
+		if len(p.idle) == 0 {
+			p.mu.Unlock()
+			break
+		}
+		ic := p.idle[len(p.idle)-1]
+		p.idle = p.idle[:len(p.idle)-1]
+		p.mu.Unlock()
+
+		if healthy(ic) {
+			return ic.conn, nil
+		}
+		ic.conn.Close()
+	}
+	return p.dialer.dial(p.addr, p.tlsName)
+}
+
+// getUntil is get, with the connection's timeout set to the deadline.
+func (p *connPool) getUntil(deadline time.Time) (*as.Connection, error) {
+	conn, err := p.get()
+	if err != nil {
+		return nil, err
+	}
+	timeout := time.Until(deadline)
+	if timeout <= 0 {
+		// SetTimeout() would remove the deadline, not enforce it
+		p.put(conn, nil)
+		return nil, errDeadline
+	}
+	if err := conn.SetTimeout(timeout); err != nil {
+		p.put(conn, err)
+		return nil, err
+	}
+	return conn, nil
+}
+
+// put gives a connection back. Connections which had an error are closed,
+// since we don't know in which state they are.
+func (p *connPool) put(conn *as.Connection, err error) {
+	if err != nil || !conn.IsConnected() {
+		conn.Close()
+		return
+	}
+
+	p.mu.Lock()
+	defer p.mu.Unlock()
+	if p.closed || len(p.idle) >= p.maxIdle {
+		conn.Close()
+		return
+	}
+	p.idle = append(p.idle, idleConn{conn: conn, since: time.Now()})
+}
+
+// close closes all idle connections. Connections which are given back after
+// this are closed as well.
+func (p *connPool) close() {
+	p.mu.Lock()
+	defer p.mu.Unlock()
+	for _, ic := range p.idle {
+		ic.conn.Close()
+	}
+	p.idle = nil
+	p.closed = true
+}
+
+// healthy checks whether an idle connection can still be used, with a cheap
+// info command if it has been idle for a while. It also resets the
+// connection's deadline.
+func healthy(ic idleConn) bool {
+	if !ic.conn.IsConnected() || time.Since(ic.since) > maxIdleTime {
+		return false
+	}
+	if err := ic.conn.SetTimeout(dialTimeout); err != nil {
+		return false
+	}
+	if time.Since(ic.since) < pingAfter {
+		return true
+	}
+	_, err := (connClient{ic.conn}).RequestInfo("node")
+	return err == nil && ic.conn.IsConnected()
+}
+
+// recoverConnError turns a panic of the client into err, and closes the
+// connection. The client panics on write errors for connections which don't
+// belong to a client.Node, which ours never do. Call it with defer.
+func recoverConnError(conn *as.Connection, err *error) {
+	if r := recover(); r != nil {
+		conn.Close()
+		*err = fmt.Errorf("connection error: %v", r)
+	}
+}
+
+const (
+	adminLogin               = 20 // the admin LOGIN command
+	adminHeaderSize          = 24 // proto header + admin header
+	adminResultCode          = 9
+	resultSecurityNotEnabled = 52
+)
+
+// pkiLogin logs in without credentials. The server takes the user from the
+// TLS client certificate. The client library we use predates PKI
+// authentication, so we send the admin command ourselves.
+func pkiLogin(conn *as.Connection) (err error) {
+	defer recoverConnError(conn, &err)
+
+	buf := make([]byte, adminHeaderSize)
+	// version 0, type 2 (admin), and the size without the proto header
+	binary.BigEndian.PutUint64(buf, uint64(adminHeaderSize-8)|2<<48)
+	buf[8+2] = adminLogin
+	buf[8+3] = 0 // no fields
+	if _, err := conn.Write(buf); err != nil {
+		return err
+	}
+
+	if _, err := conn.Read(buf, adminHeaderSize); err != nil {
+		return err
+	}
+	switch res := buf[adminResultCode]; res {
+	case 0:
+	case resultSecurityNotEnabled:
+		return nil
+	default:
+		return fmt.Errorf("login failed with result code %d", res)
+	}
+
+	// skip the session fields in the response
+	size := int(binary.BigEndian.Uint64(buf) & 0xFFFFFFFFFFFF)
+	if rest := size - (adminHeaderSize - 8); rest > 0 {
+		if _, err := conn.Read(make([]byte, rest), rest); err != nil {
+			return err
+		}
+	}
+	return nil
+}
@@ -0,0 +1,92 @@
+package main
+
+import (
+	"path/filepath"
+	"testing"
+
+	"github.com/alicebob/asprom/internal/fakeaero"
+)
+
+func TestPKILogin(t *testing.T) {
+	type cas struct {
+		name  string
+		setup func(*fakeaero.Server)
+		error string
+	}
+	addUser := func(s *fakeaero.Server) {
+		if err := s.AddUser("admin", "secret"); err != nil {
+			t.Fatal(err)
+		}
+		s.SetCertUser("admin")
+	}
+	for _, c := range []cas{
+		{
+			name: "no security",
+		},
+		{
+			name:  "login",
+			setup: addUser,
+		},
+		{
+			name: "unknown user",
+			setup: func(s *fakeaero.Server) {
+				addUser(s)
+				s.SetCertUser("root")
+			},
+			error: "PKI auth error: login failed with result code 60",
+		},
+		{
+			name: "not authenticated",
+			setup: func(s *fakeaero.Server) {
+				addUser(s)
+				s.FailLogins(fakeaero.ResultNotAuthenticated)
+			},
+			error: "PKI auth error: login failed with result code 80",
+		},
+		{
+			name: "invalid credential",
+			setup: func(s *fakeaero.Server) {
+				addUser(s)
+				s.FailLogins(fakeaero.ResultInvalidCredential)
+			},
+			error: "PKI auth error: login failed with result code 65",
+		},
+	} {
+		t.Run(c.name, func(t *testing.T) {
+			s, err := fakeaero.New()
+			if err != nil {
+				t.Fatal(err)
+			}
+			defer s.Close()
+			if err := s.Load(filepath.Join("testdata", "4.9", "asinfo.txt")); err != nil {
+				t.Fatal(err)
+			}
+			if c.setup != nil {
+				c.setup(s)
+			}
+
+			conn, err := newDialer("", "", nil, "", true).dial(s.Addr(), "")
+			haveerr := ""
+			if err != nil {
+				haveerr = err.Error()
+			}
+			if have, want := haveerr, c.error; have != want {
+				t.Fatalf("have %q, want %q", have, want)
+			}
+			if err != nil {
+				return
+			}
+			defer conn.Close()
+
+			// the session fields of the reply are skipped, and the connection
+			// is logged in
+			res, err := (connClient{conn}).RequestInfo("build")
+			if err != nil {
+				t.Fatal(err)
+			}
+			if have, want := res["build"], "4.9.0.11"; have != want {
+				t.Errorf("have %q, want %q", have, want)
+			}
+		})
+	}
+}
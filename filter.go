@@ -0,0 +1,73 @@
+package main
+
+import (
+	"fmt"
+	"regexp"
+
+	"github.com/prometheus/client_golang/prometheus"
+	dto "github.com/prometheus/client_model/go"
+)
+
+// nameFilter decides which metric names, or which namespaces, sets, etc., are
+// exported. A name is allowed if it matches an include pattern, or if there
+// are no include patterns, and if it doesn't match any exclude pattern.
+// Patterns must match the whole name. A nil filter allows everything.
+type nameFilter struct {
+	include []*regexp.Regexp
+	exclude []*regexp.Regexp
+}
+
+func newNameFilter(include, exclude []string) (*nameFilter, error) {
+	if len(include) == 0 && len(exclude) == 0 {
+		return nil, nil
+	}
+	inc, err := compilePatterns(include)
+	if err != nil {
+		return nil, fmt.Errorf("include: %s", err)
+	}
+	exc, err := compilePatterns(exclude)
+	if err != nil {
+		return nil, fmt.Errorf("exclude: %s", err)
+	}
+	return &nameFilter{
+		include: inc,
+		exclude: exc,
+	}, nil
+}
+
+func (f *nameFilter) allow(name string) bool {
+	if f == nil {
+		return true
+	}
+	if len(f.include) > 0 && !matchAny(f.include, name) {
+		return false
+	}
+	return !matchAny(f.exclude, name)
+}
+
+func matchAny(res []*regexp.Regexp, s string) bool {
+	for _, re := range res {
+		if re.MatchString(s) {
+			return true
+		}
+	}
+	return false
+}
+
+// filterGatherer drops the metric families the filter doesn't allow.
+type filterGatherer struct {
+	prometheus.Gatherer
+	filter *nameFilter
+}
+
+// Gather implements the prometheus.Gatherer interface.
+func (fg filterGatherer) Gather() ([]*dto.MetricFamily, error) {
+	mfs, err := fg.Gatherer.Gather()
+	var res []*dto.MetricFamily
+	for _, mf := range mfs {
+		if fg.filter.allow(mf.GetName()) {
+			res = append(res, mf)
+		}
+	}
+	return res, err
+}
@@ -0,0 +1,177 @@
+package main
+
+import (
+	"sort"
+	"strings"
+	"sync"
+
+	"github.com/prometheus/client_golang/prometheus"
+)
+
+// configContexts are the node wide get-config contexts we report. The
+// namespace context is reported per namespace.
+var configContexts = []string{"service", "network"}
+
+// configCollector exports the get-config settings. Numeric and bool settings
+// become aerospike_config_<context>_<key> gauges, string settings become
+// labels on aerospike_config_<context>_info.
+type configCollector struct {
+	settings   map[string]*autoMetrics // by context
+	infos      map[string]*configInfo  // by context
+	namespaces *nameFilter
+}
+
+func newConfigCollector(opts collectorOpts) configCollector {
+	cc := configCollector{
+		settings:   map[string]*autoMetrics{},
+		infos:      map[string]*configInfo{},
+		namespaces: opts.namespaces,
+	}
+	for _, ctx := range configContexts {
+		cc.add(ctx, nil, opts)
+	}
+	cc.add("namespace", []string{"namespace"}, opts)
+	return cc
+}
+
+func (cc configCollector) add(ctx string, labels []string, opts collectorOpts) {
+	sys := "config_" + ctx
+	opts.autoExport = true // settings are never declared
+	am := newAutoMetrics(sys, labels, nil, opts)
+	am.help = " (config)"
+	am.untyp = prometheus.GaugeValue
+	am.parse = parseConfig
+	cc.settings[ctx] = am
+	cc.infos[ctx] = &configInfo{
+		name:   promkey(sys, "info"),
+		help:   ctx + " string config settings. Always 1",
+		labels: labels,
+		skip:   opts.labels,
+		filter: opts.metrics,
+		descs:  map[string]*prometheus.Desc{},
+	}
+}
+
+func (cc configCollector) describe(ch chan<- *prometheus.Desc) {
+	// all descs depend on the server's settings
+}
+
+func (cc configCollector) collect(client infoClient) ([]prometheus.Metric, error) {
+	cmds := []string{"namespaces"}
+	for _, ctx := range configContexts {
+		cmds = append(cmds, "get-config:context="+ctx)
+	}
+	info, err := client.RequestInfo(cmds...)
+	if err != nil {
+		return nil, err
+	}
+	var metrics []prometheus.Metric
+	for _, ctx := range configContexts {
+		v := info["get-config:context="+ctx]
+		metrics = append(metrics, cc.settings[ctx].collect(nil, v)...)
+		metrics = append(metrics, cc.infos[ctx].collect(v)...)
+	}
+
+	var nsCmds []string
+	for _, ns := range strings.Split(info["namespaces"], ";") {
+		if ns == "" || !cc.namespaces.allow(ns) {
+			continue
+		}
+		nsCmds = append(nsCmds, "get-config:context=namespace;id="+ns)
+	}
+	if len(nsCmds) == 0 {
+		return metrics, nil
+	}
+	nsInfo, err := client.RequestInfo(nsCmds...)
+	if err != nil {
+		return nil, err
+	}
+	for _, cmd := range nsCmds {
+		ns := strings.TrimPrefix(cmd, "get-config:context=namespace;id=")
+		v := nsInfo[cmd]
+		metrics = append(metrics, cc.settings["namespace"].collect(nil, v, ns)...)
+		metrics = append(metrics, cc.infos["namespace"].collect(v, ns)...)
+	}
+	return metrics, nil
+}
+
+// configInfo makes an info metric with the string settings as labels.
+type configInfo struct {
+	name   string
+	help   string
+	labels []string
+	skip   map[string]bool // the constant labels
+	filter *nameFilter
+
+	mu    sync.Mutex
+	descs map[string]*prometheus.Desc // by label names
+}
+
+// collect returns the info metric for the string settings in info, if there
+// are any. Settings which have the same label name as an earlier one, or as a
+// constant label, are skipped.
+func (ci *configInfo) collect(info string, labelValues ...string) []prometheus.Metric {
+	if !ci.filter.allow(ci.name) {
+		return nil
+	}
+	settings := parseConfig(info)
+	keys := make([]string, 0, len(settings))
+	for k, v := range settings {
+		if _, err := parseFloatOrBool(v); err == nil || v == "" {
+			continue
+		}
+		keys = append(keys, k)
+	}
+	if len(keys) == 0 {
+		return nil
+	}
+	sort.Strings(keys)
+
+	names := append([]string{}, ci.labels...)
+	values := make([]string, 0, len(labelValues)+len(keys))
+	for _, lv := range labelValues {
+		values = append(values, sanitizeLabelValue(lv))
+	}
+	seen := map[string]bool{}
+	for _, l := range names {
+		seen[l] = true
+	}
+	for _, k := range keys {
+		l := strings.Trim(invalidNameChars.ReplaceAllString(k, "_"), "_")
+		if l == "" || seen[l] || ci.skip[l] || (l[0] >= '0' && l[0] <= '9') {
+			continue
+		}
+		seen[l] = true
+		names = append(names, l)
+		values = append(values, sanitizeLabelValue(settings[k]))
+	}
+	return []prometheus.Metric{
+		prometheus.MustNewConstMetric(ci.desc(names), prometheus.GaugeValue, 1, values...),
+	}
+}
+
+// parseConfig parses a get-config response. Unlike parseInfo it doesn't split
+// on colons, which are in values such as addresses:
+//
+//	heartbeat.mode=mesh;heartbeat.mesh-seed-address-port=10.0.0.1:3002
+func parseConfig(s string) map[string]string {
+	r := map[string]string{}
+	for _, f := range strings.Split(s, ";") {
+		if kv := strings.SplitN(f, "=", 2); len(kv) == 2 {
+			r[kv[0]] = kv[1]
+		}
+	}
+	return r
+}
+
+func (ci *configInfo) desc(names []string) *prometheus.Desc {
+	ci.mu.Lock()
+	defer ci.mu.Unlock()
+	key := strings.Join(names, ",")
+	d, ok := ci.descs[key]
+	if !ok {
+		d = prometheus.NewDesc(ci.name, ci.help, names, nil)
+		ci.descs[key] = d
+	}
+	return d
+}
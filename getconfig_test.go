@@ -0,0 +1,60 @@
+package main
+
+import (
+	"reflect"
+	"strconv"
+	"testing"
+)
+
+func TestConfigCollector(t *testing.T) {
+	cc := newConfigCollector(collectorOpts{})
+	info := "default-ttl=2592000;enable-xdr=false;storage-engine=device;conflict-resolution-policy=generation;storage-engine.file[0]=/opt/test.dat;storage_engine=dup;xdr-remote-datacenter=;tls-address=[2001:db8::1]:4333"
+	ms := append(
+		cc.settings["namespace"].collect(nil, info, "test"),
+		cc.infos["namespace"].collect(info, "test")...,
+	)
+	have := map[string]map[string]string{}
+	for _, mf := range gather(t, ms) {
+		m := mf.GetMetric()[0]
+		ls := map[string]string{"value": strconv.FormatFloat(m.GetGauge().GetValue(), 'f', -1, 64)}
+		for _, l := range m.GetLabel() {
+			ls[l.GetName()] = l.GetValue()
+		}
+		have[mf.GetName()] = ls
+	}
+	want := map[string]map[string]string{
+		"aerospike_config_namespace_default_ttl": {
+			"value":     "2592000",
+			"namespace": "test",
+		},
+		"aerospike_config_namespace_enable_xdr": {
+			"value":     "0",
+			"namespace": "test",
+		},
+		"aerospike_config_namespace_info": {
+			"value":                      "1",
+			"namespace":                  "test",
+			"conflict_resolution_policy": "generation",
+			"storage_engine":             "device",
+			"storage_engine_file_0":      "/opt/test.dat",
+			"tls_address":                "[2001:db8::1]:4333",
+		},
+	}
+	if !reflect.DeepEqual(have, want) {
+		t.Errorf("have %+v, want %+v", have, want)
+	}
+
+	if ms := cc.infos["service"].collect("proto-fd-max=15000"); ms != nil {
+		t.Errorf("expected no info metric, got %v", ms)
+	}
+
+	// constant labels win
+	cc = newConfigCollector(collectorOpts{labels: map[string]bool{"storage_engine": true}})
+	for _, mf := range gather(t, cc.infos["namespace"].collect(info, "test")) {
+		for _, l := range mf.GetMetric()[0].GetLabel() {
+			if l.GetName() == "storage_engine" {
+				t.Errorf("unexpected storage_engine label")
+			}
+		}
+	}
+}
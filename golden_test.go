@@ -0,0 +1,66 @@
+package main
+
+import (
+	"bytes"
+	"flag"
+	"io/ioutil"
+	"path/filepath"
+	"testing"
+	"time"
+
+	dto "github.com/prometheus/client_model/go"
+	"github.com/prometheus/common/expfmt"
+)
+
+var update = flag.Bool("update", false, "update the golden files in testdata/")
+
+// TestGolden runs every collector against the info responses of a node, in
+// testdata/<version>/asinfo.txt, and compares the metrics with
+// testdata/<version>/<collector>.prom. Run `go test -run TestGolden -update`
+// after changing metrics, and check the diff.
+func TestGolden(t *testing.T) {
+	// 2s after the last latency: slice in the fixtures
+	now := time.Date(2021, 6, 1, 10, 17, 40, 0, time.UTC)
+
+	for _, version := range []string{"3.15", "4.9", "5.6"} {
+		dir := filepath.Join("testdata", version)
+		for name, newCollector := range allCollectors {
+			c := newCollector(collectorOpts{})
+			if lc, ok := c.(*latencyCollector); ok {
+				lc.now = func() time.Time { return now }
+			}
+			ms, err := c.collect(batchClient{loadFakeClient(t, filepath.Join(dir, "asinfo.txt"))})
+			if err != nil {
+				t.Fatalf("%s %s: %s", version, name, err)
+			}
+
+			have := exposition(t, gather(t, ms))
+			golden := filepath.Join(dir, name+".prom")
+			if *update {
+				if err := ioutil.WriteFile(golden, []byte(have), 0644); err != nil {
+					t.Fatal(err)
+				}
+				continue
+			}
+			want, err := ioutil.ReadFile(golden)
+			if err != nil {
+				t.Fatal(err)
+			}
+			if have != string(want) {
+				t.Errorf("%s %s: have:\n%s\nwant:\n%s", version, name, have, want)
+			}
+		}
+	}
+}
+
+// exposition renders metrics in the text format.
+func exposition(t *testing.T, mfs []*dto.MetricFamily) string {
+	t.Helper()
+	var b bytes.Buffer
+	for _, mf := range mfs {
+		if _, err := expfmt.MetricFamilyToText(&b, mf); err != nil {
+			t.Fatal(err)
+		}
+	}
+	return b.String()
+}
@@ -0,0 +1,157 @@
+package main
+
+import (
+	"fmt"
+	"strconv"
+	"strings"
+
+	"github.com/prometheus/client_golang/prometheus"
+)
+
+var (
+	// HistogramTypes lists the histogram:namespace=<ns>;type=<type>
+	// histograms we report, with the unit of the buckets.
+	HistogramTypes = map[string]string{
+		"ttl":                "seconds",
+		"object-size":        "bytes",
+		"object-size-linear": "bytes",
+	}
+)
+
+// histogramUnits are the units the server uses, with their size in the unit we
+// export.
+var histogramUnits = map[string]float64{
+	"seconds": 1,
+	"bytes":   1,
+	"rblocks": 128, // object sizes before 4.2 are in 128 byte blocks
+}
+
+type histogramCollector struct {
+	histograms map[string]*prometheus.Desc // by type
+	namespaces *nameFilter
+}
+
+func newHistogramCollector(opts collectorOpts) histogramCollector {
+	hs := map[string]*prometheus.Desc{}
+	for typ, unit := range HistogramTypes {
+		name := promkey(systemHistogram, typ)
+		if !opts.metrics.allow(name) {
+			continue
+		}
+		hs[typ] = prometheus.NewDesc(
+			name,
+			typ+" histogram, le in "+unit,
+			[]string{"namespace"},
+			nil,
+		)
+	}
+	return histogramCollector{
+		histograms: hs,
+		namespaces: opts.namespaces,
+	}
+}
+
+func (hc histogramCollector) describe(ch chan<- *prometheus.Desc) {
+	for _, d := range hc.histograms {
+		ch <- d
+	}
+}
+
+func (hc histogramCollector) collect(client infoClient) ([]prometheus.Metric, error) {
+	info, err := client.RequestInfo("namespaces")
+	if err != nil {
+		return nil, err
+	}
+	var (
+		nss  []string
+		cmds []string
+	)
+	for _, ns := range strings.Split(info["namespaces"], ";") {
+		if ns == "" || !hc.namespaces.allow(ns) {
+			continue
+		}
+		nss = append(nss, ns)
+		for typ := range hc.histograms {
+			cmds = append(cmds, histogramCmd(ns, typ))
+		}
+	}
+	res, err := client.RequestInfo(cmds...)
+	if err != nil {
+		return nil, err
+	}
+
+	var metrics []prometheus.Metric
+	for _, ns := range nss {
+		for typ, desc := range hc.histograms {
+			cmd := histogramCmd(ns, typ)
+			v := res[cmd]
+			if v == "" || strings.HasPrefix(v, "error") {
+				// not supported by this server version, or not enabled for this namespace
+				continue
+			}
+			h, err := parseHistogram(v)
+			if err != nil {
+				return nil, fmt.Errorf("%s: %s", cmd, err)
+			}
+			metrics = append(
+				metrics,
+				prometheus.MustNewConstHistogram(desc, h.count, h.sum, h.buckets, ns),
+			)
+		}
+	}
+	return metrics, nil
+}
+
+func histogramCmd(ns, typ string) string {
+	return "histogram:namespace=" + ns + ";type=" + typ
+}
+
+// histogram is a parsed histogram: command.
+type histogram struct {
+	count   uint64
+	sum     float64
+	buckets map[float64]uint64 // cumulative counts, by upper bound
+}
+
+// parseHistogram parses the output of a histogram: command, which looks like:
+//
+//	units=seconds:hist-width=86400:bucket-width=864:buckets=0,12,3,...
+//
+// Bucket i has the objects from i*bucket-width up to (i+1)*bucket-width. The
+// sum is a lower bound, since every object is counted at the start of its
+// bucket.
+func parseHistogram(s string) (histogram, error) {
+	kv := map[string]string{}
+	for _, f := range strings.Split(strings.TrimSuffix(s, ";"), ":") {
+		p := strings.SplitN(f, "=", 2)
+		if len(p) != 2 {
+			return histogram{}, fmt.Errorf("invalid histogram field %q", f)
+		}
+		kv[p[0]] = p[1]
+	}
+
+	scale, ok := histogramUnits[kv["units"]]
+	if !ok {
+		return histogram{}, fmt.Errorf("unknown histogram units %q", kv["units"])
+	}
+	width, err := strconv.ParseFloat(kv["bucket-width"], 64)
+	if err != nil {
+		return histogram{}, fmt.Errorf("invalid bucket-width %q", kv["bucket-width"])
+	}
+	width *= scale
+
+	h := histogram{buckets: map[float64]uint64{}}
+	if kv["buckets"] == "" {
+		return h, nil
+	}
+	for i, b := range strings.Split(kv["buckets"], ",") {
+		n, err := strconv.ParseUint(b, 10, 64)
+		if err != nil {
+			return histogram{}, fmt.Errorf("invalid bucket %q", b)
+		}
+		h.count += n
+		h.sum += float64(i) * width * float64(n)
+		h.buckets[float64(i+1)*width] = h.count
+	}
+	return h, nil
+}
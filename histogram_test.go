@@ -0,0 +1,78 @@
+package main
+
+import (
+	"reflect"
+	"testing"
+)
+
+func TestParseHistogram(t *testing.T) {
+	type cas struct {
+		hist  string
+		error string
+		want  histogram
+	}
+	for n, c := range []cas{
+		{
+			hist: "units=seconds:hist-width=400:bucket-width=100:buckets=1,0,2,3",
+			want: histogram{
+				count: 6,
+				sum:   0*1 + 200*2 + 300*3,
+				buckets: map[float64]uint64{
+					100: 1,
+					200: 1,
+					300: 3,
+					400: 6,
+				},
+			},
+		},
+		{
+			hist: "units=rblocks:hist-width=2:bucket-width=1:buckets=4,1",
+			want: histogram{
+				count: 5,
+				sum:   128,
+				buckets: map[float64]uint64{
+					128: 4,
+					256: 5,
+				},
+			},
+		},
+		{
+			hist: "units=bytes:hist-width=0:bucket-width=8:buckets=",
+			want: histogram{
+				buckets: map[float64]uint64{},
+			},
+		},
+		{
+			hist:  "units=parsecs:hist-width=400:bucket-width=100:buckets=1",
+			error: `unknown histogram units "parsecs"`,
+		},
+		{
+			hist:  "units=seconds:hist-width=400:buckets=1",
+			error: `invalid bucket-width ""`,
+		},
+		{
+			hist:  "units=seconds:bucket-width=100:buckets=1,x",
+			error: `invalid bucket "x"`,
+		},
+		{
+			hist:  "units=seconds:nonsense",
+			error: `invalid histogram field "nonsense"`,
+		},
+	} {
+		have, err := parseHistogram(c.hist)
+		haveerr := ""
+		if err != nil {
+			haveerr = err.Error()
+		}
+		if have, want := haveerr, c.error; have != want {
+			t.Errorf("case %d: have %q, want %q", n, have, want)
+			continue
+		}
+		if err != nil {
+			continue
+		}
+		if want := c.want; !reflect.DeepEqual(have, want) {
+			t.Errorf("case %d: have %+v, want %+v", n, have, want)
+		}
+	}
+}
@@ -0,0 +1,72 @@
+package main
+
+import (
+	as "github.com/aerospike/aerospike-client-go"
+)
+
+// infoClient sends info commands to a node. Every call is a single round trip,
+// so collectors request all the commands of a stage at once (e.g. all
+// namespace/<ns> commands), and make as few calls as they can.
+type infoClient interface {
+	RequestInfo(cmds ...string) (map[string]string, error)
+}
+
+// connClient sends info commands over an Aerospike connection.
+type connClient struct {
+	conn *as.Connection
+}
+
+// RequestInfo implements infoClient.
+func (c connClient) RequestInfo(cmds ...string) (_ map[string]string, err error) {
+	defer recoverConnError(c.conn, &err)
+	return as.RequestInfo(c.conn, cmds...)
+}
+
+// batchClient sends every command once, and doesn't make a request without
+// commands.
+type batchClient struct {
+	infoClient
+}
+
+// RequestInfo implements infoClient.
+func (c batchClient) RequestInfo(cmds ...string) (map[string]string, error) {
+	cmds = uniq(cmds)
+	if len(cmds) == 0 {
+		return map[string]string{}, nil
+	}
+	return c.infoClient.RequestInfo(cmds...)
+}
+
+// prefetchClient answers requests from res if it has all the commands,
+// without a round trip. Use it to combine the first stage of a collector with
+// the commands which were needed anyway, such as build.
+type prefetchClient struct {
+	infoClient
+	res map[string]string
+}
+
+// RequestInfo implements infoClient.
+func (c prefetchClient) RequestInfo(cmds ...string) (map[string]string, error) {
+	have := map[string]string{}
+	for _, cmd := range cmds {
+		v, ok := c.res[cmd]
+		if !ok {
+			return c.infoClient.RequestInfo(cmds...)
+		}
+		have[cmd] = v
+	}
+	return have, nil
+}
+
+func uniq(cmds []string) []string {
+	seen := map[string]bool{}
+	res := cmds[:0:0]
+	for _, c := range cmds {
+		if seen[c] {
+			continue
+		}
+		seen[c] = true
+		res = append(res, c)
+	}
+	return res
+}
@@ -0,0 +1,154 @@
+package main
+
+import (
+	"reflect"
+	"testing"
+	"time"
+
+	as "github.com/aerospike/aerospike-client-go"
+
+	"github.com/alicebob/asprom/internal/fakeaero"
+)
+
+// fakeClient is an infoClient with canned info responses. Commands without a
+// response are left out of the result, like a server does with commands it
+// doesn't know. It counts round trips.
+type fakeClient struct {
+	responses map[string]string
+	trips     int
+}
+
+func (f *fakeClient) RequestInfo(cmds ...string) (map[string]string, error) {
+	f.trips++
+	res := map[string]string{}
+	for _, c := range cmds {
+		if v, ok := f.responses[c]; ok {
+			res[c] = v
+		}
+	}
+	return res, nil
+}
+
+// loadFakeClient reads the responses from a fixture file.
+func loadFakeClient(t *testing.T, filename string) *fakeClient {
+	t.Helper()
+	res, err := fakeaero.LoadResponses(filename)
+	if err != nil {
+		t.Fatal(err)
+	}
+	return &fakeClient{responses: res}
+}
+
+func TestRoundTrips(t *testing.T) {
+	node := map[string]string{
+		"build":                               "4.9.0.3",
+		"statistics":                          "cluster_size=1:cluster_key=C0758EC6A81F:cluster_integrity=true",
+		"namespaces":                          "foo;bar;baz",
+		"namespace/foo":                       "objects=1",
+		"namespace/bar":                       "objects=2",
+		"namespace/baz":                       "objects=3",
+		"sets":                                "ns=foo:set=s1:objects=1;ns=bar:set=s2:objects=2",
+		"sindex":                              "ns=foo:indexname=i1:set=s1:bin=b:type=NUMERIC:indextype=NONE:path=b;ns=bar:indexname=i2:set=s2:bin=b:type=NUMERIC:indextype=NONE:path=b",
+		"sindex/foo/i1":                       "keys=1:entries=2",
+		"sindex/bar/i2":                       "keys=3:entries=4",
+		"latency:":                            "{foo}-read:15:26:23-GMT,ops/sec,>1ms;15:26:33,1.0,0.00",
+		"dcs":                                 "dc1;dc2",
+		"dc/dc1":                              "dc_timelag=1",
+		"dc/dc2":                              "dc_timelag=2",
+		"histogram:namespace=foo;type=ttl":    "units=seconds:hist-width=2:bucket-width=1:buckets=1,2",
+		"get-config:context=service":          "proto-fd-max=15000",
+		"get-config:context=namespace;id=foo": "default-ttl=0",
+		"racks:":                              "ns=foo:rack_0=A",
+		"roster:namespace=foo":                "roster=A:pending_roster=A:observed_nodes=A",
+		"cluster-stable:":                     "C0758EC6A81F",
+		"edition":                             "Aerospike Community Edition",
+	}
+	node5 := map[string]string{
+		"build":                                      "5.6.0.4",
+		"get-config:context=xdr":                     "dcs=dc1,dc2",
+		"get-config:context=xdr;dc=dc1":              "namespaces=foo,bar",
+		"get-config:context=xdr;dc=dc2":              "namespaces=",
+		"get-stats:context=xdr;dc=dc1":               "lag=1",
+		"get-stats:context=xdr;dc=dc2":               "lag=2",
+		"get-stats:context=xdr;dc=dc1;namespace=foo": "lag=3",
+		"get-stats:context=xdr;dc=dc1;namespace=bar": "lag=4",
+	}
+
+	type cas struct {
+		collector string
+		node      map[string]string
+		trips     int
+	}
+	for _, c := range []cas{
+		{"cluster", node, 2},
+		{"config", node, 2},
+		{"histogram", node, 2},
+		{"latency", node, 1},
+		{"namespace", node, 2},
+		{"nodeinfo", node, 1},
+		{"roster", node, 2},
+		{"set", node, 1},
+		{"sindex", node, 2},
+		{"stats", node, 1},
+		{"xdr", node, 2},
+		{"xdr", node5, 3},
+	} {
+		f := &fakeClient{responses: c.node}
+		ms, err := allCollectors[c.collector](collectorOpts{}).collect(batchClient{f})
+		if err != nil {
+			t.Fatalf("%s: %s", c.collector, err)
+		}
+		if len(ms) == 0 {
+			t.Errorf("%s: no metrics", c.collector)
+		}
+		if have, want := f.trips, c.trips; have != want {
+			t.Errorf("%s: have %d round trips, want %d", c.collector, have, want)
+		}
+	}
+}
+
+// sentClient records the commands of every request.
+type sentClient [][]string
+
+func (s *sentClient) RequestInfo(cmds ...string) (map[string]string, error) {
+	*s = append(*s, cmds)
+	return map[string]string{"build": "5.0.0"}, nil
+}
+
+func TestBatched(t *testing.T) {
+	var sent sentClient
+	client := batchClient{&sent}
+	if _, err := client.RequestInfo(); err != nil {
+		t.Fatal(err)
+	}
+	if _, err := client.RequestInfo("build", "node", "build"); err != nil {
+		t.Fatal(err)
+	}
+	res, err := prefetchClient{client, map[string]string{"build": "4.0.0"}}.RequestInfo("build")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if have, want := res, map[string]string{"build": "4.0.0"}; !reflect.DeepEqual(have, want) {
+		t.Errorf("have %+v, want %+v", have, want)
+	}
+	if have, want := sent, (sentClient{{"build", "node"}}); !reflect.DeepEqual(have, want) {
+		t.Errorf("have %+v, want %+v", have, want)
+	}
+}
+
+func TestConnClientPanic(t *testing.T) {
+	s, err := fakeaero.New()
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer s.Close()
+	conn, err := as.NewConnection(s.Addr(), time.Second)
+	if err != nil {
+		t.Fatal(err)
+	}
+	// the client panics on writes to a broken connection
+	conn.Close()
+	if _, err := (connClient{conn}).RequestInfo("build"); err == nil {
+		t.Errorf("expected an error")
+	}
+}
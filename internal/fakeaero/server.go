@@ -0,0 +1,381 @@
+// Package fakeaero is a fake Aerospike node for tests. It speaks the info
+// protocol, and answers the login admin commands. Responses are canned, and
+// delays, disconnects, and auth failures can be injected.
+package fakeaero
+
+import (
+	"bufio"
+	"encoding/binary"
+	"fmt"
+	"io"
+	"net"
+	"os"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/aerospike/aerospike-client-go/pkg/bcrypt"
+)
+
+const (
+	protoVersion = 2
+	typeInfo     = 1
+	typeAdmin    = 2
+	maxSize      = 1 << 20
+
+	adminAuthenticate = 0
+	adminLogin        = 20
+	fieldUser         = 0
+	fieldCredential   = 3
+	fieldSessionToken = 5
+	fieldSessionTTL   = 6
+
+	// the salt the clients use to hash passwords
+	passwordSalt = "$2a$10$7EqJtq98hPqEX7fNZaFWoO"
+)
+
+// Result codes of the admin commands.
+const (
+	ResultOK                 byte = 0
+	ResultSecurityNotEnabled byte = 52
+	ResultInvalidUser        byte = 60
+	ResultInvalidCredential  byte = 65
+	ResultNotAuthenticated   byte = 80
+)
+
+// Server is a fake Aerospike node, listening on localhost.
+type Server struct {
+	l         net.Listener
+	quit      chan struct{}
+	closeOnce sync.Once
+	wg        sync.WaitGroup
+
+	mu         sync.Mutex
+	conns      map[net.Conn]struct{}
+	accepted   int
+	responses  map[string]string
+	users      map[string]string // user -> hashed password
+	certUser   string
+	delay      time.Duration
+	disconnect bool
+	authResult byte
+}
+
+// New starts a server. It has no responses, and no users, which means
+// security is disabled.
+func New() (*Server, error) {
+	l, err := net.Listen("tcp", "127.0.0.1:0")
+	if err != nil {
+		return nil, err
+	}
+	s := &Server{
+		l:         l,
+		quit:      make(chan struct{}),
+		conns:     map[net.Conn]struct{}{},
+		responses: map[string]string{},
+		users:     map[string]string{},
+	}
+	s.wg.Add(1)
+	go s.accept()
+	return s, nil
+}
+
+// Addr is the host:port the server listens on.
+func (s *Server) Addr() string {
+	return s.l.Addr().String()
+}
+
+// Close stops the server, and closes all connections. It can be called more
+// than once.
+func (s *Server) Close() {
+	s.closeOnce.Do(func() {
+		close(s.quit)
+		s.l.Close()
+		s.mu.Lock()
+		for c := range s.conns {
+			c.Close()
+		}
+		s.mu.Unlock()
+	})
+	s.wg.Wait()
+}
+
+// Accepted is the number of connections the server accepted.
+func (s *Server) Accepted() int {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	return s.accepted
+}
+
+// Load adds the responses from a fixture file. See LoadResponses.
+func (s *Server) Load(filename string) error {
+	res, err := LoadResponses(filename)
+	if err != nil {
+		return err
+	}
+	s.SetResponses(res)
+	return nil
+}
+
+// SetResponses adds canned info responses, by command. Commands without a
+// response are left out of the reply, as a server does with commands it
+// doesn't know.
+func (s *Server) SetResponses(res map[string]string) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	for k, v := range res {
+		s.responses[k] = v
+	}
+}
+
+// AddUser enables security, and adds a user. Info commands are only answered
+// after a login.
+func (s *Server) AddUser(user, password string) error {
+	hashed, err := bcrypt.Hash(password, passwordSalt)
+	if err != nil {
+		return err
+	}
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	s.users[user] = hashed
+	return nil
+}
+
+// SetCertUser sets the user of the TLS client certificate, which logins
+// without a user field (PKI) get. The server doesn't do TLS, so every
+// connection has this certificate. The user must be added with AddUser.
+func (s *Server) SetCertUser(user string) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	s.certUser = user
+}
+
+// SetDelay delays every reply by d.
+func (s *Server) SetDelay(d time.Duration) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	s.delay = d
+}
+
+// SetDisconnect makes the server close connections when it gets a request,
+// instead of replying.
+func (s *Server) SetDisconnect(on bool) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	s.disconnect = on
+}
+
+// FailLogins makes every login fail with the result code. Use ResultOK to
+// check the credentials again.
+func (s *Server) FailLogins(code byte) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	s.authResult = code
+}
+
+func (s *Server) accept() {
+	defer s.wg.Done()
+	for {
+		c, err := s.l.Accept()
+		if err != nil {
+			return
+		}
+		s.mu.Lock()
+		select {
+		case <-s.quit:
+			// Close() already closed the other connections
+			s.mu.Unlock()
+			c.Close()
+			return
+		default:
+		}
+		s.conns[c] = struct{}{}
+		s.accepted++
+		s.mu.Unlock()
+		s.wg.Add(1)
+		go func() {
+			defer s.wg.Done()
+			s.serve(c)
+			s.mu.Lock()
+			delete(s.conns, c)
+			s.mu.Unlock()
+			c.Close()
+		}()
+	}
+}
+
+// serve handles requests until the connection breaks.
+func (s *Server) serve(c net.Conn) {
+	authenticated := false
+	for {
+		typ, body, err := readMessage(c)
+		if err != nil {
+			return
+		}
+
+		s.mu.Lock()
+		delay, disconnect, secure := s.delay, s.disconnect, len(s.users) > 0
+		s.mu.Unlock()
+		if disconnect {
+			return
+		}
+		select {
+		case <-time.After(delay):
+		case <-s.quit:
+			return
+		}
+
+		var reply []byte
+		switch typ {
+		case typeInfo:
+			if secure && !authenticated {
+				// the server drops connections which didn't log in
+				return
+			}
+			reply = s.info(body)
+		case typeAdmin:
+			res := s.login(body)
+			authenticated = res == ResultOK
+			reply = make([]byte, 16)
+			reply[1] = res
+			if res == ResultOK && len(body) > 2 && body[2] == adminLogin {
+				reply = appendSession(reply)
+			}
+		default:
+			return
+		}
+		if err := writeMessage(c, typ, reply); err != nil {
+			return
+		}
+	}
+}
+
+// info answers newline separated info commands.
+func (s *Server) info(body []byte) []byte {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	var b strings.Builder
+	for _, cmd := range strings.Split(string(body), "\n") {
+		if v, ok := s.responses[cmd]; ok {
+			fmt.Fprintf(&b, "%s\t%s\n", cmd, v)
+		}
+	}
+	return []byte(b.String())
+}
+
+// login checks an authenticate or login admin command, and returns the result
+// code.
+func (s *Server) login(body []byte) byte {
+	if len(body) < 16 {
+		return ResultNotAuthenticated
+	}
+	if cmd := body[2]; cmd != adminAuthenticate && cmd != adminLogin {
+		return ResultNotAuthenticated
+	}
+	fields := map[byte]string{}
+	for i, off := 0, 16; i < int(body[3]); i++ {
+		if off+5 > len(body) {
+			return ResultNotAuthenticated
+		}
+		size := int(binary.BigEndian.Uint32(body[off:])) // includes the id
+		if size < 1 || off+4+size > len(body) {
+			return ResultNotAuthenticated
+		}
+		fields[body[off+4]] = string(body[off+5 : off+4+size])
+		off += 4 + size
+	}
+
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	if s.authResult != ResultOK {
+		return s.authResult
+	}
+	if len(s.users) == 0 {
+		return ResultSecurityNotEnabled
+	}
+	user, ok := fields[fieldUser]
+	pki := !ok // PKI logins have no user
+	if pki {
+		if s.certUser == "" {
+			return ResultNotAuthenticated
+		}
+		user = s.certUser
+	}
+	hashed, ok := s.users[user]
+	if !ok {
+		return ResultInvalidUser
+	}
+	if !pki && fields[fieldCredential] != hashed {
+		return ResultInvalidCredential
+	}
+	return ResultOK
+}
+
+// appendSession adds the session fields of a login reply.
+func appendSession(reply []byte) []byte {
+	reply[3] = 2
+	reply = appendField(reply, fieldSessionToken, []byte("fake-session-token"))
+	ttl := make([]byte, 4)
+	binary.BigEndian.PutUint32(ttl, 86400)
+	return appendField(reply, fieldSessionTTL, ttl)
+}
+
+func appendField(b []byte, id byte, v []byte) []byte {
+	size := make([]byte, 4)
+	binary.BigEndian.PutUint32(size, uint32(len(v)+1))
+	b = append(b, size...)
+	b = append(b, id)
+	return append(b, v...)
+}
+
+// readMessage reads a proto header, and the message.
+func readMessage(r io.Reader) (byte, []byte, error) {
+	var h [8]byte
+	if _, err := io.ReadFull(r, h[:]); err != nil {
+		return 0, nil, err
+	}
+	proto := binary.BigEndian.Uint64(h[:])
+	typ, size := byte(proto>>48), proto&0xFFFFFFFFFFFF
+	if size > maxSize {
+		return 0, nil, fmt.Errorf("message too big: %d", size)
+	}
+	body := make([]byte, size)
+	if _, err := io.ReadFull(r, body); err != nil {
+		return 0, nil, err
+	}
+	return typ, body, nil
+}
+
+func writeMessage(w io.Writer, typ byte, body []byte) error {
+	msg := make([]byte, 8+len(body))
+	binary.BigEndian.PutUint64(msg, uint64(len(body))|uint64(typ)<<48|protoVersion<<56)
+	copy(msg[8:], body)
+	_, err := w.Write(msg)
+	return err
+}
+
+// LoadResponses reads info responses from a fixture file, which has
+// "<command>\t<response>" lines. Empty lines and lines starting with # are
+// skipped.
+func LoadResponses(filename string) (map[string]string, error) {
+	fh, err := os.Open(filename)
+	if err != nil {
+		return nil, err
+	}
+	defer fh.Close()
+	res := map[string]string{}
+	s := bufio.NewScanner(fh)
+	s.Buffer(nil, maxSize)
+	for s.Scan() {
+		line := s.Text()
+		if line == "" || strings.HasPrefix(line, "#") {
+			continue
+		}
+		kv := strings.SplitN(line, "\t", 2)
+		if len(kv) != 2 {
+			return nil, fmt.Errorf("%s: no tab in %q", filename, line)
+		}
+		res[kv[0]] = kv[1]
+	}
+	return res, s.Err()
+}
@@ -3,188 +3,301 @@ package main
 import (
 	"fmt"
 	"regexp"
+	"sort"
 	"strconv"
 	"strings"
+	"sync"
+	"time"
 
-	as "github.com/aerospike/aerospike-client-go"
 	"github.com/prometheus/client_golang/prometheus"
 )
 
 var (
 	latencyMetrics = []string{"query", "query-rec-count", "read", "udf", "write"}
-	nsHeader       = regexp.MustCompile("^{(?P<namespace>.+)}-(?P<operation>.+)$")
+	// nodeLatencyMetrics are node wide, and have no namespace label.
+	nodeLatencyMetrics = []string{"batch-index"}
+	nsHeader           = regexp.MustCompile("^{(?P<namespace>.+)}-(?P<operation>.+)$")
 )
 
 type latencyCollector struct {
-	latency          cmetrics
-	latencyHistogram cmetrics
-	ops              cmetrics
-	histOps          cmetrics
-	bucketSum        cmetrics
+	latency    cmetrics
+	ops        cmetrics
+	opsTotal   cmetrics
+	histogram  cmetrics
+	sliceAge   cmetric
+	namespaces *nameFilter
+	now        func() time.Time // time.Now, unless in tests
+
+	mu    sync.Mutex
+	state map[string]*latencyState // by latency key
 }
 
-func newLatencyCollector() latencyCollector {
-	lc := latencyCollector{
-		latency:          map[string]cmetric{},
-		latencyHistogram: map[string]cmetric{},
-		ops:              map[string]cmetric{},
-		histOps:          map[string]cmetric{},
-		bucketSum:        map[string]cmetric{},
-	}
-	for _, m := range latencyMetrics {
-		lc.latency[m] = cmetric{
-			typ: prometheus.GaugeValue,
-			desc: prometheus.NewDesc(
-				promkey(systemLatency, m),
-				m+" latency",
-				[]string{"namespace", "threshold"}, // threshold to be printed as le for histogram
-				nil,
-			),
-		}
-		lc.latencyHistogram[m] = cmetric{
-			typ: prometheus.GaugeValue,
-			desc: prometheus.NewDesc(
-				// for prom histogram latency buckets, metric must end in _bucket
-				promkey(systemLatencyHist, m+"_bucket"),
-				m+" latency histogram",
-				[]string{"namespace", "le"}, // threshold to be printed as le for histogram, le="1" means ops that completed in less than 1ms
-				nil,
-			),
-		}
-		lc.histOps[m] = cmetric{
-			typ: prometheus.GaugeValue,
-			desc: prometheus.NewDesc(
-				// for prom histogram, must have a metric ending in _count which is equal to the sum of all observed events
-				promkey(systemLatencyHist, m+"_count"),
-				m+" ops per second for histogram",
-				[]string{"namespace"},
-				nil,
-			),
-		}
-		lc.ops[m] = cmetric{
-			typ: prometheus.GaugeValue,
-			desc: prometheus.NewDesc(
-				promkey(systemOps, m),
-				m+" ops per second",
-				[]string{"namespace"},
-				nil,
-			),
-		}
-		lc.bucketSum[m] = cmetric{
+// latencyState has the counters for a single latency key. They are estimated
+// by integrating the reported ops/sec over the time since the previous slice.
+// Every slice is counted once, no matter how often it's scraped. The latencies:
+// command doesn't report the slice time, and there every scrape is counted.
+type latencyState struct {
+	last    time.Time // when this was last integrated
+	slice   time.Time // end of the last integrated slice
+	count   float64
+	sum     float64
+	buckets map[float64]float64 // cumulative counts, by upper bound in ms
+}
+
+func newLatencyCollector(opts collectorOpts) *latencyCollector {
+	lc := &latencyCollector{
+		latency:    map[string]cmetric{},
+		ops:        map[string]cmetric{},
+		opsTotal:   map[string]cmetric{},
+		histogram:  map[string]cmetric{},
+		namespaces: opts.namespaces,
+		state:      map[string]*latencyState{},
+		now:        time.Now,
+		sliceAge: cmetric{
 			typ: prometheus.GaugeValue,
 			desc: prometheus.NewDesc(
-				// for prom histogram, must have a metric ending in _sum which is equal to the sum of all observed events values
-				promkey(systemLatencyHist, m+"_sum"),
-				m+" sum of all buckets",
-				[]string{"namespace"},
+				promkey(systemLatency, "slice_age_seconds"),
+				"age of the last reported latency slice. Empty namespace for batch-index",
+				[]string{"namespace", "op"},
 				nil,
 			),
-		}
+		},
+	}
+	for _, m := range latencyMetrics {
+		lc.add(m, "namespace")
+	}
+	for _, m := range nodeLatencyMetrics {
+		lc.add(m)
 	}
 	return lc
 }
 
-func (lc latencyCollector) describe(ch chan<- *prometheus.Desc) {
+// add the metrics for a single operation. labels are the labels common to all
+// metrics of the operation.
+func (lc *latencyCollector) add(m string, labels ...string) {
+	with := func(l ...string) []string {
+		return append(append([]string{}, labels...), l...)
+	}
+	lc.latency[m] = cmetric{
+		typ: prometheus.GaugeValue,
+		desc: prometheus.NewDesc(
+			promkey(systemLatency, m),
+			m+" latency",
+			with("threshold"),
+			nil,
+		),
+	}
+	lc.ops[m] = cmetric{
+		typ: prometheus.GaugeValue,
+		desc: prometheus.NewDesc(
+			promkey(systemOps, m),
+			m+" ops per second",
+			with(),
+			nil,
+		),
+	}
+	lc.opsTotal[m] = cmetric{
+		typ: prometheus.CounterValue,
+		desc: prometheus.NewDesc(
+			promkey(systemOps, m+"_total"),
+			m+" ops (estimated)",
+			with(),
+			nil,
+		),
+	}
+	lc.histogram[m] = cmetric{
+		desc: prometheus.NewDesc(
+			// buckets are in ms, le="1" means ops that completed in 1ms or less
+			promkey(systemLatencyHist, m),
+			m+" latency histogram in ms (estimated)",
+			with(),
+			nil,
+		),
+	}
+}
+
+func (lc *latencyCollector) describe(ch chan<- *prometheus.Desc) {
 	for _, s := range lc.latency {
 		ch <- s.desc
 	}
 	for _, s := range lc.ops {
 		ch <- s.desc
 	}
-	for _, s := range lc.histOps {
+	for _, s := range lc.opsTotal {
 		ch <- s.desc
 	}
-	for _, s := range lc.bucketSum {
-		ch <- s.desc
-	}
-	for _, s := range lc.latencyHistogram {
+	for _, s := range lc.histogram {
 		ch <- s.desc
 	}
+	ch <- lc.sliceAge.desc
 }
 
-func (lc latencyCollector) collect(conn *as.Connection) ([]prometheus.Metric, error) {
-	stats, err := as.RequestInfo(conn, "latency:")
+func (lc *latencyCollector) collect(client infoClient) ([]prometheus.Metric, error) {
+	// latency: was replaced by latencies: in 5.1. Ask for both, so we need a
+	// single round trip.
+	stats, err := client.RequestInfo("build", "latency:", "latencies:")
 	if err != nil {
 		return nil, err
 	}
-	lat, err := parseLatency(stats["latency:"])
+	v, err := parseVersion(stats["build"])
 	if err != nil {
 		return nil, err
 	}
-	var metrics []prometheus.Metric
-	re := regexp.MustCompile("[0-9.]+") // regex to pull the number from the bucket name, >1ms -> 1, >8ms -> 8 etc.
+	now := lc.now()
+	var lat map[string]latencySlice
+	if v.atLeast(5, 1) {
+		lat, err = parseLatencies(stats["latencies:"])
+	} else {
+		lat, err = parseLatency(stats["latency:"], now)
+	}
+	if err != nil {
+		return nil, err
+	}
+	return lc.metrics(lat, now)
+}
 
-	for key, ms := range lat {
-		if key == "batch-index" {
-			continue // TODO: would be nice to do something with this key
+// metrics converts the parsed latency: or latencies: output to metrics, and
+// updates the histograms.
+func (lc *latencyCollector) metrics(lat map[string]latencySlice, now time.Time) ([]prometheus.Metric, error) {
+	lc.mu.Lock()
+	defer lc.mu.Unlock()
+
+	var metrics []prometheus.Metric
+	for key, slice := range lat {
+		var (
+			op     = key
+			labels []string // namespace, if any
+		)
+		if !isNodeLatency(key) {
+			ns, nsOp, err := readNS(key)
+			if err != nil {
+				return nil, fmt.Errorf("weird latency key %q: %s", key, err)
+			}
+			if !lc.namespaces.allow(ns) {
+				continue
+			}
+			op, labels = nsOp, []string{ns}
 		}
-		ns, op, err := readNS(key)
-		if err != nil {
-			return nil, fmt.Errorf("weird latency key %q: %s", key, err)
+		if _, ok := lc.ops[op]; !ok {
+			continue
+		}
+		with := func(l ...string) []string {
+			return append(append([]string{}, labels...), l...)
 		}
-		// need to grab ops outside of the latency loop
-		// so that we can use it for estimatedBucketOps later
-		// the latency map could be out of order, so OPS/S needs to be accessed first
-		ops := ms["ops/sec"]
-		var bucketSum float64
-		histOpsMetric := lc.histOps[op]
-		metrics = append(
-			metrics,
-			prometheus.MustNewConstMetric(histOpsMetric.desc, histOpsMetric.typ, ops, ns),
-		)
 
-		opsMetric := lc.ops[op]
+		// samples are timestamped with the end of the slice, if we know it
+		stamp := func(m prometheus.Metric) prometheus.Metric {
+			if slice.end.IsZero() {
+				return m
+			}
+			return prometheus.NewMetricWithTimestamp(slice.end, m)
+		}
+		ms := slice.values
+		ops := ms["ops/sec"]
+		m := lc.ops[op]
 		metrics = append(
 			metrics,
-			prometheus.MustNewConstMetric(opsMetric.desc, opsMetric.typ, ops, ns),
+			stamp(prometheus.MustNewConstMetric(m.desc, m.typ, ops, with()...)),
 		)
-
 		for threshold, data := range ms {
 			if threshold == "ops/sec" {
 				continue
 			}
-			thresholdNum := re.FindString(threshold) // filter out >1ms to just the number 1, similarly >8ms becomes 8..
-			bucketVal, _ := strconv.ParseFloat(thresholdNum, 64)
-			m := lc.latencyHistogram[op]
-			// latency is exported as % in certain buckets.
-			// For histogram consumption, it would be nice to have the estimated number of
-			// operations in each bucket instead. So this is just some simple math to figure out, given the total ops/s and % in each bucket
-			// How many ops in each bucket.
-			estimatedBucketOps := ops * data / 100.0
-			bucketSum += (bucketVal * estimatedBucketOps) // to generate sum like aerospike_latency_hist_read_sum
-			if bucketVal == 1.0 {
-				below1msAssumption := ops - estimatedBucketOps
-				bucketSum += 0.5 * below1msAssumption // for the blahblah_sum histogram metric, to calculate averages, assume the transactions <1ms are .5ms
-			}
-			leBucketOps := ops - estimatedBucketOps
+			m := lc.latency[op]
 			metrics = append(
 				metrics,
-				prometheus.MustNewConstMetric(m.desc, m.typ, leBucketOps, ns, thresholdNum),
+				stamp(prometheus.MustNewConstMetric(m.desc, m.typ, data, with(threshold)...)),
 			)
-			m = lc.latency[op]
+		}
+		if !slice.end.IsZero() {
+			// batch-index has an empty namespace
+			ns := ""
+			if len(labels) > 0 {
+				ns = labels[0]
+			}
 			metrics = append(
 				metrics,
-				prometheus.MustNewConstMetric(m.desc, m.typ, data, ns, threshold),
+				prometheus.MustNewConstMetric(lc.sliceAge.desc, lc.sliceAge.typ, now.Sub(slice.end).Seconds(), ns, op),
 			)
 		}
-		m := lc.bucketSum[op]
-		metrics = append(
-			metrics,
-			prometheus.MustNewConstMetric(m.desc, m.typ, bucketSum, ns),
-		)
-		m = lc.latencyHistogram[op]
+
+		st, ok := lc.state[key]
+		switch {
+		case !ok:
+			st = &latencyState{buckets: map[float64]float64{}}
+			lc.state[key] = st
+			st.last, st.slice = now, slice.end
+		case slice.end.IsZero() || slice.end.After(st.slice):
+			st.integrate(ms, now.Sub(st.last).Seconds())
+			st.last, st.slice = now, slice.end
+		default:
+			// this slice, or a newer one, is already counted
+		}
+
+		buckets := map[float64]uint64{}
+		for le, c := range st.buckets {
+			buckets[le] = uint64(c)
+		}
+		m = lc.opsTotal[op]
 		metrics = append(
 			metrics,
-			prometheus.MustNewConstMetric(m.desc, m.typ, ops, ns, "+Inf"),
+			prometheus.MustNewConstMetric(m.desc, m.typ, st.count, with()...),
+			prometheus.MustNewConstHistogram(lc.histogram[op].desc, uint64(st.count), st.sum, buckets, with()...),
 		)
 	}
 	return metrics, nil
 }
 
-// parseLatency returns map with: "[{namespace}]-[op]" -> map[threshold]measurement
-// It doesn't interprets the keys.
-func parseLatency(lat string) (map[string]map[string]float64, error) {
-	results := map[string]map[string]float64{}
+// integrate adds the ops of a time slice of the given length in seconds.
+// The reported values are the percentages of ops which took longer than a
+// threshold, which makes the ops at or below a threshold the cumulative count
+// of that bucket. The sum is estimated by counting every op at the lower
+// bound of its bucket, so it's a lower bound of the real sum.
+func (st *latencyState) integrate(ms map[string]float64, secs float64) {
+	ops := ms["ops/sec"] * secs
+	if ops <= 0 {
+		return
+	}
+	st.count += ops
+
+	thresholds := make([]float64, 0, len(ms))
+	above := map[float64]float64{} // ops above a threshold
+	for k, v := range ms {
+		if k == "ops/sec" {
+			continue
+		}
+		t, err := strconv.ParseFloat(strings.TrimSuffix(strings.TrimPrefix(k, ">"), "ms"), 64)
+		if err != nil {
+			continue
+		}
+		thresholds = append(thresholds, t)
+		above[t] = ops * v / 100
+	}
+	sort.Float64s(thresholds)
+	for i, t := range thresholds {
+		st.buckets[t] += ops - above[t]
+		// ops between this threshold and the next one
+		n := above[t]
+		if i+1 < len(thresholds) {
+			n -= above[thresholds[i+1]]
+		}
+		if n > 0 {
+			st.sum += t * n
+		}
+	}
+}
+
+// latencySlice is a single latency measurement, as reported by the server.
+type latencySlice struct {
+	end    time.Time          // not reported by latencies:
+	values map[string]float64 // threshold -> measurement, and "ops/sec"
+}
+
+// parseLatency returns map with: "[{namespace}]-[op]" -> slice
+// It doesn't interprets the keys. The server only reports the time of day of
+// a slice, now is used to find the date.
+func parseLatency(lat string, now time.Time) (map[string]latencySlice, error) {
+	results := map[string]latencySlice{}
 	// Lines come in pairs, and look like this:
 	// reads:{namespace}-read:14:08:38-GMT,ops/sec,>1ms,>8ms,>64ms;14:08:48,2586.8,1.58,0.77,0.00;
 	lines := strings.Split(lat, ";")
@@ -194,7 +307,9 @@ func parseLatency(lat string) (map[string]map[string]float64, error) {
 			continue
 		}
 		vs := strings.Split(line, ",")
-		key := strings.SplitN(vs[0], ":", 2)[0] // strips timestamp
+		// The start of the slice is skipped. Ops are counted over the time
+		// between scrapes, not over slices.
+		key := strings.SplitN(vs[0], ":", 2)[0]
 		cols := vs[1:]
 		if i+1 >= len(lines) {
 			return nil, fmt.Errorf("latency: missing measurements line")
@@ -214,11 +329,96 @@ func parseLatency(lat string) (map[string]map[string]float64, error) {
 			}
 			ms[cols[i]] = f
 		}
-		results[key] = ms
+		end, err := sliceTime(measurements[0], now)
+		if err != nil {
+			return nil, fmt.Errorf("%q invalid latency time: %s", key, err)
+		}
+		results[key] = latencySlice{
+			end:    end,
+			values: ms,
+		}
 	}
 	return results, nil
 }
 
+// sliceTime converts a "14:08:48" GMT time of day to the time closest to
+// now.
+func sliceTime(clock string, now time.Time) (time.Time, error) {
+	c, err := time.Parse("15:04:05", clock)
+	if err != nil {
+		return time.Time{}, err
+	}
+	now = now.UTC()
+	t := time.Date(now.Year(), now.Month(), now.Day(), c.Hour(), c.Minute(), c.Second(), 0, time.UTC)
+	switch d := t.Sub(now); {
+	case d > 12*time.Hour:
+		t = t.AddDate(0, 0, -1)
+	case d < -12*time.Hour:
+		t = t.AddDate(0, 0, 1)
+	}
+	return t, nil
+}
+
+// parseLatencies parses the output of the latencies: command (5.1+), into
+// the same format as parseLatency. Lines look like this:
+//
+//	{test}-read:msec,2586.8,1.58,1.02,0.77,0.31,0.01,0.00,...
+//
+// The first value is the unit, the second ops/sec, and then the percentage
+// of ops above 2^0, 2^1, 2^2, ... units. Thresholds are always reported in
+// ms, so they can be used in the same metrics as parseLatency's.
+func parseLatencies(lat string) (map[string]latencySlice, error) {
+	results := map[string]latencySlice{}
+	for _, line := range strings.Split(lat, ";") {
+		kv := strings.SplitN(line, ":", 2)
+		if len(kv) != 2 || kv[1] == "" {
+			// no data for this histogram
+			continue
+		}
+		key := kv[0]
+		vs := strings.Split(kv[1], ",")
+		if len(vs) < 2 {
+			return nil, fmt.Errorf("invalid latencies format: %q", line)
+		}
+
+		var scale float64 // to ms
+		switch vs[0] {
+		case "msec":
+			scale = 1
+		case "usec":
+			scale = 0.001
+		default:
+			return nil, fmt.Errorf("%q unknown latencies unit %q", key, vs[0])
+		}
+
+		ms := map[string]float64{}
+		for i, v := range vs[1:] {
+			f, err := strconv.ParseFloat(v, 64)
+			if err != nil {
+				return nil, fmt.Errorf("%q invalid latency value %q: %s", key, v, err)
+			}
+			if i == 0 {
+				ms["ops/sec"] = f
+				continue
+			}
+			threshold := float64(uint64(1)<<uint(i-1)) * scale
+			ms[">"+strconv.FormatFloat(threshold, 'f', -1, 64)+"ms"] = f
+		}
+		results[key] = latencySlice{values: ms}
+	}
+	return results, nil
+}
+
+// isNodeLatency is true for latency keys which are not per namespace.
+func isNodeLatency(key string) bool {
+	for _, m := range nodeLatencyMetrics {
+		if key == m {
+			return true
+		}
+	}
+	return false
+}
+
 // readNS converts a key like "{foo}-bar" to "foo", "bar"
 func readNS(s string) (string, string, error) {
 	m := nsHeader.FindStringSubmatch(s)
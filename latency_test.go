@@ -2,73 +2,99 @@ package main
 
 import (
 	"reflect"
+	"strings"
 	"testing"
+	"time"
+
+	dto "github.com/prometheus/client_model/go"
 )
 
 func TestParseLatency(t *testing.T) {
+	at := func(clock string) time.Time {
+		t, err := time.Parse("2006-01-02 15:04:05", "2020-01-01 "+clock)
+		if err != nil {
+			panic(err)
+		}
+		return t
+	}
 	type cas struct {
 		lat   string
 		error string
-		want  map[string]map[string]float64
+		want  map[string]latencySlice
 	}
 	for _, c := range []cas{
 		{
 			lat: "error-no-data-yet-or-back-too-small;error-no-data-yet-or-back-too-small;{sys}-read:15:26:23-GMT,ops/sec,>1ms,>8ms,>64ms;15:26:33,54.4,1.10,0.55,0.00;{test}-write:15:26:23-GMT,ops/sec,>1ms,>8ms,>64ms;15:26:33,4.0,0.00,0.00,0.00;error-no-data-yet-or-back-too-small;{sys}-query:15:26:23-GMT,ops/sec,>1ms,>8ms,>64ms;15:26:33,0.2,100.00,0.00,0.00",
-			want: map[string]map[string]float64{
-				"{sys}-read": map[string]float64{
-					"ops/sec": 54.4,
-					">1ms":    1.10,
-					">8ms":    0.55,
-					">64ms":   0.0,
+			want: map[string]latencySlice{
+				"{sys}-read": {
+					end: at("15:26:33"),
+					values: map[string]float64{
+						"ops/sec": 54.4,
+						">1ms":    1.10,
+						">8ms":    0.55,
+						">64ms":   0.0,
+					},
 				},
-				"{test}-write": map[string]float64{
-					"ops/sec": 4.0,
-					">1ms":    0.0,
-					">8ms":    0.0,
-					">64ms":   0.0,
+				"{test}-write": {
+					end: at("15:26:33"),
+					values: map[string]float64{
+						"ops/sec": 4.0,
+						">1ms":    0.0,
+						">8ms":    0.0,
+						">64ms":   0.0,
+					},
 				},
-				"{sys}-query": map[string]float64{
-					"ops/sec": 0.2,
-					">1ms":    100.00,
-					">8ms":    0.0,
-					">64ms":   0.0,
+				"{sys}-query": {
+					end: at("15:26:33"),
+					values: map[string]float64{
+						"ops/sec": 0.2,
+						">1ms":    100.00,
+						">8ms":    0.0,
+						">64ms":   0.0,
+					},
 				},
 			},
 		},
 		{
 			lat: "error-no-data-yet-or-back-too-small;error-no-data-yet-or-back-too-small;error-no-data-yet-or-back-too-small;error-no-data-yet-or-back-too-small;{rz}-read:12:33:49-GMT,ops/sec,>1ms,>4ms,>8ms,>16ms,>32ms,>64ms,>128ms,>256ms,>512ms,>1024ms,>2048ms,>4096ms,>8192ms;12:33:59,0.4,0.00,0.00,0.00,0.00,0.00,0.00,0.00,0.00,0.00,0.00,0.00,0.00,0.00;error-no-data-yet-or-back-too-small;{rz}-udf:12:33:49-GMT,ops/sec,>1ms,>4ms,>8ms,>16ms,>32ms,>64ms,>128ms,>256ms,>512ms,>1024ms,>2048ms,>4096ms,>8192ms;12:33:59,0.5,20.00,0.00,0.00,0.00,0.00,0.00,0.00,0.00,0.00,0.00,0.00,0.00,0.00;error-no-data-yet-or-back-too-small",
-			want: map[string]map[string]float64{
-				"{rz}-read": map[string]float64{
-					"ops/sec": 0.4,
-					">1ms":    0.0,
-					">4ms":    0.0,
-					">8ms":    0.0,
-					">16ms":   0.0,
-					">32ms":   0.0,
-					">64ms":   0.0,
-					">128ms":  0.0,
-					">256ms":  0.0,
-					">512ms":  0.0,
-					">1024ms": 0.0,
-					">2048ms": 0.0,
-					">4096ms": 0.0,
-					">8192ms": 0.0,
+			want: map[string]latencySlice{
+				"{rz}-read": {
+					end: at("12:33:59"),
+					values: map[string]float64{
+						"ops/sec": 0.4,
+						">1ms":    0.0,
+						">4ms":    0.0,
+						">8ms":    0.0,
+						">16ms":   0.0,
+						">32ms":   0.0,
+						">64ms":   0.0,
+						">128ms":  0.0,
+						">256ms":  0.0,
+						">512ms":  0.0,
+						">1024ms": 0.0,
+						">2048ms": 0.0,
+						">4096ms": 0.0,
+						">8192ms": 0.0,
+					},
 				},
-				"{rz}-udf": map[string]float64{
-					"ops/sec": 0.5,
-					">1ms":    20.0,
-					">4ms":    0.0,
-					">8ms":    0.0,
-					">16ms":   0.0,
-					">32ms":   0.0,
-					">64ms":   0.0,
-					">128ms":  0.0,
-					">256ms":  0.0,
-					">512ms":  0.0,
-					">1024ms": 0.0,
-					">2048ms": 0.0,
-					">4096ms": 0.0,
-					">8192ms": 0.0,
+				"{rz}-udf": {
+					end: at("12:33:59"),
+					values: map[string]float64{
+						"ops/sec": 0.5,
+						">1ms":    20.0,
+						">4ms":    0.0,
+						">8ms":    0.0,
+						">16ms":   0.0,
+						">32ms":   0.0,
+						">64ms":   0.0,
+						">128ms":  0.0,
+						">256ms":  0.0,
+						">512ms":  0.0,
+						">1024ms": 0.0,
+						">2048ms": 0.0,
+						">4096ms": 0.0,
+						">8192ms": 0.0,
+					},
 				},
 			},
 		},
@@ -77,7 +103,241 @@ func TestParseLatency(t *testing.T) {
 			error: "latency: missing measurements line",
 		},
 	} {
-		res, err := parseLatency(c.lat)
+		res, err := parseLatency(c.lat, at("16:00:00"))
+		haveerr := ""
+		if err != nil {
+			haveerr = err.Error()
+		}
+		if have, want := haveerr, c.error; have != want {
+			t.Errorf("have %q, want %q", have, want)
+			continue
+		}
+		if have, want := res, c.want; !reflect.DeepEqual(have, want) {
+			t.Errorf("have %+v, want %+v", have, want)
+		}
+	}
+}
+
+func TestSliceTime(t *testing.T) {
+	type cas struct {
+		clock string
+		now   time.Time
+		want  time.Time
+		error string
+	}
+	for n, c := range []cas{
+		{
+			clock: "14:08:48",
+			now:   time.Date(2020, 1, 1, 14, 8, 50, 0, time.UTC),
+			want:  time.Date(2020, 1, 1, 14, 8, 48, 0, time.UTC),
+		},
+		{
+			clock: "23:59:58",
+			now:   time.Date(2020, 1, 2, 0, 0, 3, 0, time.UTC),
+			want:  time.Date(2020, 1, 1, 23, 59, 58, 0, time.UTC),
+		},
+		{
+			// server clock is a bit ahead
+			clock: "00:00:01",
+			now:   time.Date(2020, 1, 1, 23, 59, 59, 0, time.UTC),
+			want:  time.Date(2020, 1, 2, 0, 0, 1, 0, time.UTC),
+		},
+		{
+			clock: "now",
+			error: `parsing time "now" as "15:04:05": cannot parse "now" as "15"`,
+		},
+	} {
+		have, err := sliceTime(c.clock, c.now)
+		haveerr := ""
+		if err != nil {
+			haveerr = err.Error()
+		}
+		if have, want := haveerr, c.error; have != want {
+			t.Errorf("case %d: have %q, want %q", n, have, want)
+			continue
+		}
+		if want := c.want; !have.Equal(want) {
+			t.Errorf("case %d: have %s, want %s", n, have, want)
+		}
+	}
+}
+
+func TestLatencyMetrics(t *testing.T) {
+	t0 := time.Date(2020, 1, 1, 15, 26, 33, 0, time.UTC)
+	lat, err := parseLatency("batch-index:15:26:23-GMT,ops/sec,>1ms,>8ms,>64ms;15:26:33,10.0,20.00,10.00,0.00;{test}-read:15:26:23-GMT,ops/sec,>1ms,>8ms,>64ms;15:26:33,54.4,1.10,0.55,0.00", t0)
+	if err != nil {
+		t.Fatal(err)
+	}
+	lc := newLatencyCollector(collectorOpts{})
+	if _, err := lc.metrics(map[string]latencySlice{
+		"batch-index": {end: t0.Add(-10 * time.Second)},
+		"{test}-read": {end: t0.Add(-10 * time.Second)},
+	}, t0); err != nil {
+		t.Fatal(err)
+	}
+	ms, err := lc.metrics(lat, t0.Add(10*time.Second))
+	if err != nil {
+		t.Fatal(err)
+	}
+	// family -> label names
+	have := map[string]string{}
+	var (
+		hist *dto.Histogram
+		ops  *dto.Metric
+		age  *dto.Metric
+	)
+	for _, mf := range gather(t, ms) {
+		switch mf.GetName() {
+		case "aerospike_latency_hist_batch_index":
+			hist = mf.GetMetric()[0].GetHistogram()
+		case "aerospike_ops_batch_index":
+			ops = mf.GetMetric()[0]
+		case "aerospike_latency_slice_age_seconds":
+			age = mf.GetMetric()[0]
+		}
+		var ls []string
+		for _, l := range mf.GetMetric()[0].GetLabel() {
+			ls = append(ls, l.GetName())
+		}
+		have[mf.GetName()] = strings.Join(ls, ",")
+	}
+	want := map[string]string{
+		"aerospike_latency_batch_index":       "threshold",
+		"aerospike_latency_hist_batch_index":  "",
+		"aerospike_ops_batch_index":           "",
+		"aerospike_ops_batch_index_total":     "",
+		"aerospike_latency_read":              "namespace,threshold",
+		"aerospike_latency_hist_read":         "namespace",
+		"aerospike_ops_read":                  "namespace",
+		"aerospike_ops_read_total":            "namespace",
+		"aerospike_latency_slice_age_seconds": "namespace,op",
+	}
+	if !reflect.DeepEqual(have, want) {
+		t.Errorf("have %+v, want %+v", have, want)
+	}
+
+	// samples are from the end of the slice
+	if have, want := ops.GetTimestampMs(), t0.UnixNano()/int64(time.Millisecond); have != want {
+		t.Errorf("have %d, want %d", have, want)
+	}
+	if have, want := age.GetGauge().GetValue(), 10.0; have != want {
+		t.Errorf("have %v, want %v", have, want)
+	}
+
+	// 10 ops/sec for 10 seconds
+	if have, want := hist.GetSampleCount(), uint64(100); have != want {
+		t.Errorf("have %d, want %d", have, want)
+	}
+	if have, want := hist.GetSampleSum(), 1.0*10+8.0*10; have != want {
+		t.Errorf("have %v, want %v", have, want)
+	}
+	buckets := map[float64]uint64{}
+	for _, b := range hist.GetBucket() {
+		buckets[b.GetUpperBound()] = b.GetCumulativeCount()
+	}
+	if have, want := buckets, map[float64]uint64{1: 80, 8: 90, 64: 100}; !reflect.DeepEqual(have, want) {
+		t.Errorf("have %+v, want %+v", have, want)
+	}
+}
+
+func TestLatencyCounters(t *testing.T) {
+	lc := newLatencyCollector(collectorOpts{})
+	total := func(lat string, now time.Time) float64 {
+		t.Helper()
+		l, err := parseLatency(lat, now)
+		if err != nil {
+			t.Fatal(err)
+		}
+		ms, err := lc.metrics(l, now)
+		if err != nil {
+			t.Fatal(err)
+		}
+		for _, mf := range gather(t, ms) {
+			if mf.GetName() == "aerospike_ops_read_total" {
+				return mf.GetMetric()[0].GetCounter().GetValue()
+			}
+		}
+		t.Fatal("no aerospike_ops_read_total")
+		return 0
+	}
+
+	t0 := time.Date(2020, 1, 1, 15, 26, 33, 0, time.UTC)
+	slice1 := "{test}-read:15:26:23-GMT,ops/sec,>1ms;15:26:33,10.0,0.00"
+	slice2 := "{test}-read:15:26:33-GMT,ops/sec,>1ms;15:26:43,20.0,0.00"
+	for _, c := range []struct {
+		lat  string
+		now  time.Time
+		want float64
+	}{
+		{slice1, t0, 0},
+		{slice1, t0.Add(5 * time.Second), 0}, // same slice
+		{slice2, t0.Add(10 * time.Second), 200},
+		{slice2, t0.Add(15 * time.Second), 200}, // same slice
+		{slice1, t0.Add(30 * time.Second), 200}, // older slice
+	} {
+		if have, want := total(c.lat, c.now), c.want; have != want {
+			t.Errorf("have %v, want %v", have, want)
+		}
+	}
+}
+
+func TestParseLatencies(t *testing.T) {
+	type cas struct {
+		lat   string
+		error string
+		want  map[string]latencySlice
+	}
+	for _, c := range []cas{
+		{
+			lat: "batch-index:;{test}-read:msec,54.4,1.10,0.80,0.55,0.00;{test}-write:msec,4.0,0.00,0.00,0.00,0.00;{test}-udf:;{test}-query:",
+			want: map[string]latencySlice{
+				"{test}-read": {
+					values: map[string]float64{
+						"ops/sec": 54.4,
+						">1ms":    1.10,
+						">2ms":    0.80,
+						">4ms":    0.55,
+						">8ms":    0.0,
+					},
+				},
+				"{test}-write": {
+					values: map[string]float64{
+						"ops/sec": 4.0,
+						">1ms":    0.0,
+						">2ms":    0.0,
+						">4ms":    0.0,
+						">8ms":    0.0,
+					},
+				},
+			},
+		},
+		{
+			lat: "{bar}-read:usec,12.0,90.00,50.00,10.00",
+			want: map[string]latencySlice{
+				"{bar}-read": {
+					values: map[string]float64{
+						"ops/sec":  12.0,
+						">0.001ms": 90.0,
+						">0.002ms": 50.0,
+						">0.004ms": 10.0,
+					},
+				},
+			},
+		},
+		{
+			lat:   "{bar}-read:nsec,12.0,90.00",
+			error: `"{bar}-read" unknown latencies unit "nsec"`,
+		},
+		{
+			lat:   "{bar}-read:msec",
+			error: `invalid latencies format: "{bar}-read:msec"`,
+		},
+		{
+			lat:   "{bar}-read:msec,1.0,foo",
+			error: `"{bar}-read" invalid latency value "foo": strconv.ParseFloat: parsing "foo": invalid syntax`,
+		},
+	} {
+		res, err := parseLatencies(c.lat)
 		haveerr := ""
 		if err != nil {
 			haveerr = err.Error()
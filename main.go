@@ -1,7 +1,8 @@
 // Aerospike prometheus exporter
 //
 // Collects statistics for a single Aerospike node and makes it available as
-// metrics for Prometheus.
+// metrics for Prometheus. With -discover it collects from all nodes in the
+// cluster, and with /probe?target=... it collects from any node.
 //
 // Statistics collected:
 //   aerospike_node_*: node wide statistics. e.g. memory usage, cluster state.
@@ -9,17 +10,22 @@
 //   aerospike_sets_*: statistics per set: objects, memory usage
 //   aerospike_latency_*: read/write/etc latency rates(!) (as asinfo -v "latency:" reports").
 //   aerospike_ops_*: read/write/etc ops per second
+//   aerospike_exporter_collector_*: success and duration per collector
 package main
 
 import (
+	"errors"
 	"flag"
 	"fmt"
 	"log"
 	"net/http"
 	"os"
+	"os/signal"
+	"strconv"
+	"sync"
+	"syscall"
 	"time"
 
-	as "github.com/aerospike/aerospike-client-go"
 	"github.com/aerospike/aerospike-client-go/pkg/bcrypt"
 	"github.com/prometheus/client_golang/prometheus"
 	"github.com/prometheus/client_golang/prometheus/promhttp"
@@ -34,22 +40,44 @@ const (
 	systemLatencyHist = "latency_hist" // total number of ops
 	systemOps         = "ops"
 	systemSet         = "set"
+	systemHistogram   = "histogram"
+	systemRoster      = "roster"
+	systemRack        = "rack"
+	systemCluster     = "cluster"
+	systemExporter    = "exporter"
 	xdrDC             = "xdr"
+
+	// used when Prometheus doesn't tell us its scrape timeout
+	defaultScrapeTimeout = 10 * time.Second
+	scrapeTimeoutOffset  = 500 * time.Millisecond
 )
 
 var (
 	version     = "master"
 	showVersion = flag.Bool("version", false, "show version")
+	configFile  = flag.String("config", "", "YAML config file. If set, all other flags are ignored. Reloaded on SIGHUP.")
 	addr        = flag.String("listen", ":9145", "listen address for prometheus. ENV variable EXPORTER_ADDRESS")
 	nodeAddr    = flag.String("node", "127.0.0.1:3000", "aerospike node")
 	username    = flag.String("username", "", "username. Leave empty for no authentication. ENV variable AS_USERNAME, if set, will override this.")
 	password    = flag.String("password", "", "password. ENV variable AS_PASSWORD, if set, will override this.")
+	tlsEnabled  = flag.Bool("tls", false, "connect to the nodes over TLS. Verifies the nodes' certificates with the system CAs, unless there is a -tls-ca-file.")
+	tlsCAFile   = flag.String("tls-ca-file", "", "CA certificate(s) to verify the nodes' TLS certificates. Enables TLS.")
+	tlsCertFile = flag.String("tls-cert-file", "", "client certificate for mutual TLS. Enables TLS.")
+	tlsKeyFile  = flag.String("tls-key-file", "", "client key for mutual TLS")
+	tlsName     = flag.String("tls-name", "", "the nodes' tls-name. Defaults to the host of the node address.")
+	tlsMin      = flag.String("tls-min-version", "", "minimum TLS version: 1.0, 1.1, 1.2, or 1.3")
+	pki         = flag.Bool("pki", false, "authenticate with the TLS client certificate instead of username and password")
+	discover    = flag.Bool("discover", false, "collect from all nodes in the cluster of -node, found via its peers list. All metrics get a 'node' label.")
+	clusterSize = flag.Int("cluster-size", 0, "expected number of nodes in the cluster. aerospike_cluster_stable is 0 if the cluster has a different size. 0 doesn't check the size.")
+	autoExport  = flag.Bool("auto-export", false, "export every numeric statistic, not only the known ones. Unknown statistics are untyped.")
+	allowTarget = flag.String("allow-targets", "", "comma separated list of host:port targets which can be used with /probe. Leave empty to allow any target, which is only possible without -username and -pki.")
 
 	landingPage = `<html>
 <head><title>Aerospike exporter</title></head>
 <body>
 <h1>Aerospike exporter</h1>
 <p><a href="/metrics">Metrics</a></p>
+<p><a href="/probe?target=127.0.0.1:3000">Probe 127.0.0.1:3000</a></p>
 </body>
 </html>`
 
@@ -59,6 +87,20 @@ var (
 		nil,
 		nil,
 	)
+	collectorSuccessDesc = prometheus.NewDesc(
+		namespace+"_"+systemExporter+"_collector_success",
+		"Did the collector succeed",
+		[]string{"collector"},
+		nil,
+	)
+	collectorDurationDesc = prometheus.NewDesc(
+		namespace+"_"+systemExporter+"_collector_duration_seconds",
+		"Duration of the collector",
+		[]string{"collector"},
+		nil,
+	)
+
+	errDeadline = errors.New("deadline exceeded")
 )
 
 func main() {
@@ -87,33 +129,220 @@ func main() {
 		os.Exit(0)
 	}
 
-	col := newAsCollector(*nodeAddr, *username, *password)
-
-	req := prometheus.NewRegistry()
-	req.MustRegister(col)
+	var (
+		cfg *config
+		err error
+	)
+	if *configFile != "" {
+		cfg, err = loadConfig(*configFile)
+	} else {
+		cfg, err = configFromFlags()
+	}
+	if err != nil {
+		log.Fatal(err)
+	}
+	ex, err := newExporter(cfg)
+	if err != nil {
+		log.Fatal(err)
+	}
+	current := &currentExporter{ex: ex}
+	if *configFile != "" {
+		go reloadOnHUP(*configFile, cfg.Listen, current)
+	}
 
 	http.HandleFunc("/", func(w http.ResponseWriter, r *http.Request) {
 		w.Write([]byte(landingPage))
 	})
-	http.Handle("/metrics", promhttp.HandlerFor(req, promhttp.HandlerOpts{ErrorLog: log.New(os.Stdout, "err: ", 0)}))
-	log.Printf("starting asprom. listening on %s\n", *addr)
-	log.Fatal(http.ListenAndServe(*addr, nil))
+	http.HandleFunc("/metrics", func(w http.ResponseWriter, r *http.Request) {
+		current.get().metrics.ServeHTTP(w, r)
+	})
+	http.HandleFunc("/probe", func(w http.ResponseWriter, r *http.Request) {
+		current.get().probe.ServeHTTP(w, r)
+	})
+	log.Printf("starting asprom. listening on %s\n", cfg.Listen)
+	log.Fatal(http.ListenAndServe(cfg.Listen, nil))
+}
+
+// configFromFlags makes a config out of the command line flags.
+func configFromFlags() (*config, error) {
+	cfg := &config{
+		Listen:       *addr,
+		Nodes:        []string{*nodeAddr},
+		Discover:     *discover,
+		ClusterSize:  *clusterSize,
+		AllowTargets: parseTargets(*allowTarget),
+		Username:     *username,
+		Password:     *password,
+	}
+	cfg.TLS.Enabled = *tlsEnabled
+	cfg.TLS.CAFile = *tlsCAFile
+	cfg.TLS.CertFile = *tlsCertFile
+	cfg.TLS.KeyFile = *tlsKeyFile
+	cfg.TLS.Name = *tlsName
+	cfg.TLS.MinVersion = *tlsMin
+	cfg.TLS.PKI = *pki
+	cfg.Metrics.AutoExport = *autoExport
+	return cfg, cfg.validate()
+}
+
+// exporter has the HTTP handlers for a config.
+type exporter struct {
+	metrics http.Handler
+	probe   *probeHandler
+	close   func()
+}
+
+func newExporter(cfg *config) (*exporter, error) {
+	tlsConfig, err := cfg.tlsOptions().config()
+	if err != nil {
+		return nil, err
+	}
+	d := newDialer(cfg.Username, cfg.Password, tlsConfig, cfg.TLS.Name, cfg.TLS.PKI)
+
+	copts, err := cfg.collectorOpts()
+	if err != nil {
+		return nil, err
+	}
+	opts := scrapeOpts{
+		labels: cfg.labels(),
+		filter: copts.metrics,
+	}
+
+	var (
+		register registerFunc
+		closeFn  func()
+	)
+	if cfg.Discover {
+		cl := newCluster(cfg.Nodes, d, cfg.collectors(), copts)
+		register, closeFn = cl.register, cl.close
+	} else {
+		asc := newAsCollector(cfg.Nodes[0], d, cfg.collectors(), copts)
+		register, closeFn = asc.register, asc.close
+	}
+	probe := newProbeHandler(d, cfg.collectors(), copts, cfg.AllowTargets, opts)
+	return &exporter{
+		metrics: opts.handler(register),
+		probe:   probe,
+		close: func() {
+			closeFn()
+			probe.close()
+		},
+	}, nil
+}
+
+// currentExporter is the exporter for the latest valid config.
+type currentExporter struct {
+	mu sync.Mutex
+	ex *exporter
+}
+
+func (ce *currentExporter) get() *exporter {
+	ce.mu.Lock()
+	defer ce.mu.Unlock()
+	return ce.ex
+}
+
+// swap replaces the exporter, and closes the old one.
+func (ce *currentExporter) swap(ex *exporter) {
+	ce.mu.Lock()
+	old := ce.ex
+	ce.ex = ex
+	ce.mu.Unlock()
+	old.close()
+}
+
+// reloadOnHUP reloads the config file on every SIGHUP. Invalid configs are
+// logged, and the previous config stays active. The listener keeps running, so
+// the listen address can't be changed this way.
+func reloadOnHUP(filename, listen string, current *currentExporter) {
+	hup := make(chan os.Signal, 1)
+	signal.Notify(hup, syscall.SIGHUP)
+	for range hup {
+		cfg, err := loadConfig(filename)
+		if err != nil {
+			log.Printf("config reload: %s", err)
+			continue
+		}
+		if cfg.Listen != listen {
+			log.Printf("config reload: changing the listen address needs a restart. Still listening on %s", listen)
+		}
+		ex, err := newExporter(cfg)
+		if err != nil {
+			log.Printf("config reload: %s", err)
+			continue
+		}
+		current.swap(ex)
+		log.Printf("config reloaded from %s", filename)
+	}
+}
+
+// registerFunc registers the collectors for a single scrape, which has to
+// finish before the deadline.
+type registerFunc func(reg prometheus.Registerer, deadline time.Time) error
+
+// scrapeOpts apply to every scrape.
+type scrapeOpts struct {
+	labels prometheus.Labels // added to every metric
+	filter *nameFilter       // for metrics which don't come from infoCollect
+}
+
+// handler serves the metrics. The deadline comes from the scrape timeout
+// Prometheus sends along.
+func (so scrapeOpts) handler(rf registerFunc) http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		reg := prometheus.NewRegistry()
+		if err := rf(prometheus.WrapRegistererWith(so.labels, reg), scrapeDeadline(r)); err != nil {
+			log.Print(err)
+			http.Error(w, err.Error(), http.StatusInternalServerError)
+			return
+		}
+		g := filterGatherer{Gatherer: reg, filter: so.filter}
+		promhttp.HandlerFor(g, promhttp.HandlerOpts{ErrorLog: log.New(os.Stdout, "err: ", 0)}).ServeHTTP(w, r)
+	})
+}
+
+// scrapeDeadline uses the X-Prometheus-Scrape-Timeout-Seconds header, minus a
+// little time to write the response.
+func scrapeDeadline(r *http.Request) time.Time {
+	timeout := defaultScrapeTimeout
+	if v := r.Header.Get("X-Prometheus-Scrape-Timeout-Seconds"); v != "" {
+		if f, err := strconv.ParseFloat(v, 64); err == nil && f > 0 {
+			timeout = time.Duration(f * float64(time.Second))
+		}
+	}
+	if timeout > 2*scrapeTimeoutOffset {
+		timeout -= scrapeTimeoutOffset
+	}
+	return time.Now().Add(timeout)
 }
 
 type collector interface {
-	collect(*as.Connection) ([]prometheus.Metric, error)
+	collect(infoClient) ([]prometheus.Metric, error)
 	describe(ch chan<- *prometheus.Desc)
 }
 
+// allCollectors has the constructors of all collectors, by name.
+var allCollectors = map[string]func(collectorOpts) collector{
+	"cluster":   func(o collectorOpts) collector { return newStabilityCollector(o) },
+	"config":    func(o collectorOpts) collector { return newConfigCollector(o) },
+	"histogram": func(o collectorOpts) collector { return newHistogramCollector(o) },
+	"latency":   func(o collectorOpts) collector { return newLatencyCollector(o) },
+	"namespace": func(o collectorOpts) collector { return newNSCollector(o) },
+	"nodeinfo":  func(o collectorOpts) collector { return newNodeInfoCollector(o) },
+	"roster":    func(o collectorOpts) collector { return newRosterCollector(o) },
+	"set":       func(o collectorOpts) collector { return newSetCollector(o) },
+	"sindex":    func(o collectorOpts) collector { return newSindexCollector(o) },
+	"stats":     func(o collectorOpts) collector { return newStatsCollector(o) },
+	"xdr":       func(o collectorOpts) collector { return newXdrCollector(o) },
+}
+
 type asCollector struct {
-	nodeAddr     string
-	username     string
-	password     string
+	pool         *connPool
 	totalScrapes prometheus.Counter
-	collectors   []collector
+	collectors   map[string]collector
 }
 
-func newAsCollector(nodeAddr, username, password string) *asCollector {
+func newAsCollector(nodeAddr string, d *dialer, names []string, opts collectorOpts) *asCollector {
 	totalScrapes := prometheus.NewCounter(prometheus.CounterOpts{
 		Namespace: namespace,
 		Subsystem: systemNode,
@@ -121,19 +350,14 @@ func newAsCollector(nodeAddr, username, password string) *asCollector {
 		Help:      "Total number of times Aerospike was scraped for metrics.",
 	})
 
+	collectors := map[string]collector{}
+	for _, n := range names {
+		collectors[n] = allCollectors[n](opts)
+	}
 	return &asCollector{
-		nodeAddr:     nodeAddr,
-		username:     username,
-		password:     password,
+		pool:         newConnPool(nodeAddr, d, len(collectors)),
 		totalScrapes: totalScrapes,
-		collectors: []collector{
-			newLatencyCollector(),
-			newNSCollector(),
-			newSetCollector(),
-			newSindexCollector(),
-			newStatsCollector(),
-			newXdrDCCollector(),
-		},
+		collectors:   collectors,
 	}
 }
 
@@ -141,6 +365,8 @@ func newAsCollector(nodeAddr, username, password string) *asCollector {
 func (asc *asCollector) Describe(ch chan<- *prometheus.Desc) {
 	asc.totalScrapes.Describe(ch)
 	ch <- upDesc
+	ch <- collectorSuccessDesc
+	ch <- collectorDurationDesc
 	for _, c := range asc.collectors {
 		c.describe(ch)
 	}
@@ -148,10 +374,24 @@ func (asc *asCollector) Describe(ch chan<- *prometheus.Desc) {
 
 // Collect implements the prometheus.Collector interface.
 func (asc *asCollector) Collect(ch chan<- prometheus.Metric) {
+	asc.collectUntil(ch, time.Now().Add(defaultScrapeTimeout))
+}
+
+// register is a registerFunc for this node.
+func (asc *asCollector) register(reg prometheus.Registerer, deadline time.Time) error {
+	return reg.Register(deadlineCollector{asc, deadline})
+}
+
+// close closes idle connections.
+func (asc *asCollector) close() {
+	asc.pool.close()
+}
+
+func (asc *asCollector) collectUntil(ch chan<- prometheus.Metric, deadline time.Time) {
 	asc.totalScrapes.Inc()
 	ch <- asc.totalScrapes
 
-	ms, err := asc.collect()
+	ms, err := asc.collect(deadline)
 	if err != nil {
 		log.Print(err)
 		ch <- prometheus.MustNewConstMetric(upDesc, prometheus.GaugeValue, 0.0)
@@ -163,34 +403,100 @@ func (asc *asCollector) Collect(ch chan<- prometheus.Metric) {
 	}
 }
 
-func (asc *asCollector) collect() ([]prometheus.Metric, error) {
-	conn, err := as.NewConnection(asc.nodeAddr, 3*time.Second)
+// collect runs all collectors concurrently, each on its own connection. An
+// error is only returned if the node can't be reached at all. Failing
+// collectors, and collectors which didn't finish before the deadline, are
+// reported via collectorSuccessDesc.
+func (asc *asCollector) collect(deadline time.Time) ([]prometheus.Metric, error) {
+	// check the node is reachable before we try every collector
+	conn, err := asc.pool.get()
 	if err != nil {
 		return nil, err
 	}
-	defer conn.Close()
+	asc.pool.put(conn, nil)
 
-	if asc.username != "" {
-		hp, err := hashPassword(asc.password)
-		if err != nil {
-			return nil, fmt.Errorf("hashPassword: %s", err)
-		}
-		if err := conn.Authenticate(asc.username, hp); err != nil {
-			return nil, fmt.Errorf("auth error: %s", err)
-		}
+	type result struct {
+		name    string
+		metrics []prometheus.Metric
+	}
+	var (
+		start   = time.Now()
+		results = make(chan result, len(asc.collectors))
+		timeout = time.NewTimer(time.Until(deadline))
+	)
+	defer timeout.Stop()
+	for name, c := range asc.collectors {
+		go func(name string, c collector) {
+			results <- result{name, asc.collectOne(name, c, deadline)}
+		}(name, c)
 	}
 
-	var metrics []prometheus.Metric
-	for _, c := range asc.collectors {
-		ms, err := c.collect(conn)
-		if err != nil {
-			return nil, err
+	var (
+		metrics []prometheus.Metric
+		done    = map[string]bool{}
+	)
+	for len(done) < len(asc.collectors) {
+		select {
+		case r := <-results:
+			done[r.name] = true
+			metrics = append(metrics, r.metrics...)
+		case <-timeout.C:
+			for name := range asc.collectors {
+				if done[name] {
+					continue
+				}
+				log.Printf("collector %s: deadline exceeded", name)
+				metrics = append(metrics, collectorStatus(name, false, time.Since(start))...)
+			}
+			return metrics, nil
 		}
-		metrics = append(metrics, ms...)
 	}
 	return metrics, nil
 }
 
+// collectOne runs a single collector, and adds its success and duration
+// metrics.
+func (asc *asCollector) collectOne(name string, c collector, deadline time.Time) []prometheus.Metric {
+	start := time.Now()
+	ms, err := asc.run(c, deadline)
+	if err != nil {
+		log.Printf("collector %s: %s", name, err)
+		ms = nil
+	}
+	return append(ms, collectorStatus(name, err == nil, time.Since(start))...)
+}
+
+func (asc *asCollector) run(c collector, deadline time.Time) (_ []prometheus.Metric, err error) {
+	conn, err := asc.pool.getUntil(deadline)
+	if err != nil {
+		return nil, err
+	}
+	defer func() { asc.pool.put(conn, err) }()
+	return c.collect(batchClient{connClient{conn}})
+}
+
+func collectorStatus(name string, success bool, d time.Duration) []prometheus.Metric {
+	s := 0.0
+	if success {
+		s = 1.0
+	}
+	return []prometheus.Metric{
+		prometheus.MustNewConstMetric(collectorSuccessDesc, prometheus.GaugeValue, s, name),
+		prometheus.MustNewConstMetric(collectorDurationDesc, prometheus.GaugeValue, d.Seconds(), name),
+	}
+}
+
+// deadlineCollector is an asCollector for a single scrape.
+type deadlineCollector struct {
+	*asCollector
+	deadline time.Time
+}
+
+// Collect implements the prometheus.Collector interface.
+func (dc deadlineCollector) Collect(ch chan<- prometheus.Metric) {
+	dc.collectUntil(ch, dc.deadline)
+}
+
 // take from github.com/aerospike/aerospike-client-go/admin_command.go
 func hashPassword(password string) ([]byte, error) {
 	// Hashing the password with the cost of 10, with a static salt
@@ -0,0 +1,172 @@
+package main
+
+import (
+	"net/http/httptest"
+	"path/filepath"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/prometheus/client_golang/prometheus"
+
+	"github.com/alicebob/asprom/internal/fakeaero"
+)
+
+func TestScrapeDeadline(t *testing.T) {
+	for header, want := range map[string]time.Duration{
+		"":     defaultScrapeTimeout - scrapeTimeoutOffset,
+		"foo":  defaultScrapeTimeout - scrapeTimeoutOffset,
+		"-1":   defaultScrapeTimeout - scrapeTimeoutOffset,
+		"5":    4500 * time.Millisecond,
+		"0.75": 750 * time.Millisecond,
+	} {
+		r := httptest.NewRequest("GET", "/metrics", nil)
+		if header != "" {
+			r.Header.Set("X-Prometheus-Scrape-Timeout-Seconds", header)
+		}
+		start := time.Now()
+		have := scrapeDeadline(r).Sub(start)
+		if have < want || have > want+100*time.Millisecond {
+			t.Errorf("%q: have %s, want %s", header, have, want)
+		}
+	}
+}
+
+func TestCollect(t *testing.T) {
+	type cas struct {
+		name     string
+		setup    func(*fakeaero.Server)
+		user     string
+		password string
+		timeout  time.Duration // 0 to use Collect()
+		want     []string
+	}
+	addUser := func(s *fakeaero.Server) {
+		if err := s.AddUser("admin", "secret"); err != nil {
+			t.Fatal(err)
+		}
+	}
+	for _, c := range []cas{
+		{
+			name: "no security",
+			want: []string{
+				"aerospike_node_up 1",
+				`aerospike_exporter_collector_success{collector="stats"} 1`,
+				`aerospike_node_info{build="4.9.0.11",cluster_name="demo",edition="Aerospike Enterprise Edition",features="peers;cdt-list;cdt-map;cluster-stable;pipelining;geo;float;batch-index;replicas;replicas-all;replicas-master;replicas-prole;udf",node_id="BB9030011AC4202",service="172.17.0.3:3000"} 1`,
+			},
+		},
+		{
+			name:     "login",
+			setup:    addUser,
+			user:     "admin",
+			password: "secret",
+			want: []string{
+				"aerospike_node_up 1",
+				`aerospike_exporter_collector_success{collector="stats"} 1`,
+			},
+		},
+		{
+			name:     "wrong password",
+			setup:    addUser,
+			user:     "admin",
+			password: "wrong",
+			want:     []string{"aerospike_node_up 0"},
+		},
+		{
+			name:     "unknown user",
+			setup:    addUser,
+			user:     "root",
+			password: "secret",
+			want:     []string{"aerospike_node_up 0"},
+		},
+		{
+			name: "login fails",
+			setup: func(s *fakeaero.Server) {
+				addUser(s)
+				s.FailLogins(fakeaero.ResultNotAuthenticated)
+			},
+			user:     "admin",
+			password: "secret",
+			want:     []string{"aerospike_node_up 0"},
+		},
+		{
+			name:  "node gone",
+			setup: func(s *fakeaero.Server) { s.Close() },
+			want:  []string{"aerospike_node_up 0"},
+		},
+		{
+			// the node is reachable, but every collector fails
+			name:  "disconnects",
+			setup: func(s *fakeaero.Server) { s.SetDisconnect(true) },
+			want: []string{
+				"aerospike_node_up 1",
+				`aerospike_exporter_collector_success{collector="stats"} 0`,
+			},
+		},
+		{
+			name:    "slow node",
+			setup:   func(s *fakeaero.Server) { s.SetDelay(time.Second) },
+			timeout: 100 * time.Millisecond,
+			want: []string{
+				"aerospike_node_up 1",
+				`aerospike_exporter_collector_success{collector="stats"} 0`,
+			},
+		},
+	} {
+		t.Run(c.name, func(t *testing.T) {
+			s, err := fakeaero.New()
+			if err != nil {
+				t.Fatal(err)
+			}
+			defer s.Close()
+			if err := s.Load(filepath.Join("testdata", "4.9", "asinfo.txt")); err != nil {
+				t.Fatal(err)
+			}
+			addr := s.Addr()
+			if c.setup != nil {
+				c.setup(s)
+			}
+
+			asc := newAsCollector(addr, newDialer(c.user, c.password, nil, "", false), collectorNames(), collectorOpts{})
+			defer asc.close()
+			var col prometheus.Collector = asc
+			if c.timeout != 0 {
+				col = deadlineCollector{asc, time.Now().Add(c.timeout)}
+			}
+			reg := prometheus.NewRegistry()
+			reg.MustRegister(col)
+			mfs, err := reg.Gather()
+			if err != nil {
+				t.Fatal(err)
+			}
+			have := exposition(t, mfs)
+			for _, want := range c.want {
+				if !strings.Contains(have, want+"\n") {
+					t.Errorf("no %q in:\n%s", want, have)
+				}
+			}
+		})
+	}
+}
+
+func TestRunDeadline(t *testing.T) {
+	s, err := fakeaero.New()
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer s.Close()
+	if err := s.Load(filepath.Join("testdata", "4.9", "asinfo.txt")); err != nil {
+		t.Fatal(err)
+	}
+	s.SetDelay(time.Second)
+
+	asc := newAsCollector(s.Addr(), newDialer("", "", nil, "", false), []string{"stats"}, collectorOpts{})
+	defer asc.close()
+	start := time.Now()
+	if _, err := asc.run(asc.collectors["stats"], start.Add(-time.Millisecond)); err != errDeadline {
+		t.Errorf("have %v, want %v", err, errDeadline)
+	}
+	if d := time.Since(start); d > 500*time.Millisecond {
+		t.Errorf("run took %s", d)
+	}
+}
@@ -4,8 +4,10 @@ import (
 	"encoding/hex"
 	"fmt"
 	"log"
+	"regexp"
 	"strconv"
 	"strings"
+	"sync"
 	"unicode/utf8"
 
 	"github.com/prometheus/client_golang/prometheus"
@@ -24,6 +26,30 @@ type cmetric struct {
 	typ  prometheus.ValueType
 }
 
+var invalidNameChars = regexp.MustCompile("[^a-zA-Z0-9_]+")
+
+// collectorOpts are the options for all collectors.
+type collectorOpts struct {
+	// autoExport exports every numeric stat, not only the ones we know.
+	autoExport bool
+	// types overrides the type of auto exported metrics, by metric name.
+	// The default is untyped.
+	types map[string]prometheus.ValueType
+	// metrics filters by metric name. The other filters filter by label
+	// value.
+	metrics    *nameFilter
+	namespaces *nameFilter
+	sets       *nameFilter
+	sindexes   *nameFilter
+	dcs        *nameFilter
+	// clusterSize is the expected number of nodes in the cluster. 0 if
+	// unknown.
+	clusterSize int
+	// labels are the constant labels added to every metric. Collectors with
+	// dynamic label names must skip these.
+	labels map[string]bool
+}
+
 func parseFloatOrBool(v string) (float64, error) {
 	if f, err := strconv.ParseFloat(v, 64); err == nil {
 		return f, nil
@@ -44,9 +70,17 @@ func infoCollect(
 	metrics cmetrics,
 	info string,
 	labelValues ...string,
+) []prometheus.Metric {
+	return collectStats(metrics, parseInfo(info), labelValues...)
+}
+
+// collectStats is infoCollect for already parsed info.
+func collectStats(
+	metrics cmetrics,
+	stats map[string]string,
+	labelValues ...string,
 ) []prometheus.Metric {
 	var res []prometheus.Metric
-	stats := parseInfo(info)
 	validLabelValues := make([]string, len(labelValues))
 	for pos, lv := range labelValues {
 		validLabelValues[pos] = sanitizeLabelValue(lv)
@@ -70,6 +104,103 @@ func infoCollect(
 	return res
 }
 
+// autoMetrics makes metrics for the keys in an info result which have no
+// declared metric. Keys which don't have a numeric or bool value are ignored.
+type autoMetrics struct {
+	sys    string
+	labels []string
+	types  map[string]prometheus.ValueType
+	filter *nameFilter
+	known  map[string]bool      // names of declared metrics
+	help   string               // appended to the key for the help text
+	untyp  prometheus.ValueType // type of keys not in types
+	parse  func(string) map[string]string
+
+	mu    sync.Mutex
+	descs map[string]*prometheus.Desc // by aerospike key
+}
+
+// newAutoMetrics returns nil if auto export is disabled.
+func newAutoMetrics(sys string, labels []string, declared []metric, opts collectorOpts) *autoMetrics {
+	if !opts.autoExport {
+		return nil
+	}
+	known := map[string]bool{}
+	for _, m := range declared {
+		known[promkey(sys, m.aeroName)] = true
+	}
+	return &autoMetrics{
+		sys:    sys,
+		labels: labels,
+		types:  opts.types,
+		filter: opts.metrics,
+		known:  known,
+		help:   " (auto exported)",
+		untyp:  prometheus.UntypedValue,
+		parse:  parseInfo,
+		descs:  map[string]*prometheus.Desc{},
+	}
+}
+
+// collect returns the metrics for the keys in info which aren't in declared.
+// Safe to call on a nil autoMetrics.
+func (am *autoMetrics) collect(
+	declared cmetrics,
+	info string,
+	labelValues ...string,
+) []prometheus.Metric {
+	if am == nil {
+		return nil
+	}
+	var res []prometheus.Metric
+	validLabelValues := make([]string, len(labelValues))
+	for pos, lv := range labelValues {
+		validLabelValues[pos] = sanitizeLabelValue(lv)
+	}
+	for key, v := range am.parse(info) {
+		if _, ok := declared[key]; ok {
+			continue
+		}
+		f, err := parseFloatOrBool(v)
+		if err != nil {
+			continue
+		}
+		name := am.name(key)
+		if am.known[name] || !am.filter.allow(name) {
+			// known: e.g. "foo-bar" when we declared "foo_bar"
+			continue
+		}
+		res = append(
+			res,
+			prometheus.MustNewConstMetric(am.desc(key, name), am.typ(name), f, validLabelValues...),
+		)
+	}
+	return res
+}
+
+func (am *autoMetrics) name(key string) string {
+	k := strings.Trim(invalidNameChars.ReplaceAllString(key, "_"), "_")
+	return promkey(am.sys, k)
+}
+
+func (am *autoMetrics) desc(key, name string) *prometheus.Desc {
+	am.mu.Lock()
+	defer am.mu.Unlock()
+	d, ok := am.descs[key]
+	if !ok {
+		d = prometheus.NewDesc(name, key+am.help, am.labels, nil)
+		am.descs[key] = d
+	}
+	return d
+}
+
+func (am *autoMetrics) typ(name string) prometheus.ValueType {
+	if t, ok := am.types[name]; ok {
+		return t
+	}
+	return am.untyp
+}
+
 func sanitizeLabelValue(lv string) string {
 	if utf8.ValidString(lv) {
 		return lv
@@ -84,6 +215,15 @@ func sanitizeLabelValue(lv string) string {
 	return strings.Map(fixUtf, lv) + " " + hex.EncodeToString([]byte(lv))
 }
 
+// joinInfo is the opposite of parseInfo.
+func joinInfo(stats map[string]string) string {
+	var b strings.Builder
+	for k, v := range stats {
+		b.WriteString(k + "=" + v + ";")
+	}
+	return b.String()
+}
+
 func parseInfo(s string) map[string]string {
 	r := map[string]string{}
 	for _, l := range strings.Split(s, ";") {
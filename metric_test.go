@@ -118,7 +118,7 @@ func TestInfoCollect(t *testing.T) {
 			},
 			//labels: []string{"ns", ""},
 			labels: []string{"ns", "\xC0"},
-			want: `label:<name:"namespace" value:"ns" > label:<name:"set" value:"\357\277\275 c0" > gauge:<value:1 > `,
+			want:   `label:<name:"namespace" value:"ns" > label:<name:"set" value:"\357\277\275 c0" > gauge:<value:1 > `,
 		},
 		{
 			payload: "counter-1=3.14:gauge-1=6.12:flag=true:counter-2=6.66",
@@ -134,7 +134,7 @@ func TestInfoCollect(t *testing.T) {
 			},
 			//labels: []string{"ns", ""},
 			labels: []string{"ns", "ӕ"},
-			want: `label:<name:"namespace" value:"ns" > label:<name:"set" value:"\323\225" > gauge:<value:1 > `,
+			want:   `label:<name:"namespace" value:"ns" > label:<name:"set" value:"\323\225" > gauge:<value:1 > `,
 		},
 	} {
 		metrics := cmetrics{c.field: c.metric}
@@ -150,4 +150,74 @@ func TestInfoCollect(t *testing.T) {
 			t.Errorf("case %d: have %q, want %q", n, have, want)
 		}
 	}
-}
\ No newline at end of file
+}
+
+func TestAutoMetrics(t *testing.T) {
+	declared := []metric{
+		gauge("objects", "objects"),
+		counter("foo_bar", "foo bar"),
+	}
+	known := cmetrics{}
+	for _, m := range declared {
+		known[m.aeroName] = cmetric{
+			typ:  m.typ,
+			desc: prometheus.NewDesc(promkey(systemSet, m.aeroName), m.desc, []string{"namespace"}, nil),
+		}
+	}
+	am := newAutoMetrics(
+		systemSet,
+		[]string{"namespace"},
+		declared,
+		collectorOpts{
+			autoExport: true,
+			types: map[string]prometheus.ValueType{
+				"aerospike_set_new_counter": prometheus.CounterValue,
+			},
+		},
+	)
+	ms := am.collect(
+		known,
+		"objects=12:foo-bar=3:new_counter=4:new.gauge=5:enabled=true:name=hello:weird[0]=6",
+		"test",
+	)
+	have := map[string]string{}
+	for _, mf := range gather(t, ms) {
+		have[mf.GetName()] = mf.GetType().String()
+	}
+	want := map[string]string{
+		"aerospike_set_new_counter": "COUNTER",
+		"aerospike_set_new_gauge":   "UNTYPED",
+		"aerospike_set_enabled":     "UNTYPED",
+		"aerospike_set_weird_0":     "UNTYPED",
+	}
+	if !reflect.DeepEqual(have, want) {
+		t.Errorf("have %+v, want %+v", have, want)
+	}
+
+	if am := newAutoMetrics(systemSet, nil, declared, collectorOpts{}); am.collect(known, "new=1") != nil {
+		t.Errorf("expected no auto metrics")
+	}
+}
+
+// metricList is an unchecked collector for a fixed list of metrics.
+type metricList []prometheus.Metric
+
+func (ml metricList) Describe(chan<- *prometheus.Desc) {}
+
+func (ml metricList) Collect(ch chan<- prometheus.Metric) {
+	for _, m := range ml {
+		ch <- m
+	}
+}
+
+// gather runs metrics through a registry, which also checks them.
+func gather(t *testing.T, ms []prometheus.Metric) []*dto.MetricFamily {
+	t.Helper()
+	reg := prometheus.NewPedanticRegistry()
+	reg.MustRegister(metricList(ms))
+	mfs, err := reg.Gather()
+	if err != nil {
+		t.Fatal(err)
+	}
+	return mfs
+}
@@ -1,10 +1,11 @@
 package main
 
 import (
-	"bytes"
+	"regexp"
+	"sort"
+	"strconv"
 	"strings"
 
-	as "github.com/aerospike/aerospike-client-go"
 	"github.com/prometheus/client_golang/prometheus"
 )
 
@@ -12,6 +13,7 @@ var (
 	// NamespaceMetrics lists the keys we report from aero's namespace statistics command.
 	// See `asinfo -l -v namespace/<namespace>` for the full list.
 	NamespaceMetrics = []metric{
+		// config settings, these are exported by the config collector:
 		// allow-nonxdr-writes=true
 		// allow-xdr-writes=true
 		// cache_read_pct=0
@@ -233,10 +235,14 @@ var (
 		gauge("dead_partitions", "dead partitions"),
 		gauge("unavailable_partitions", "unavailable partitions"),
 		gauge("rack-id", "rack id"),
-		// device-level stats don't appear to work
-		// and this plugin thinks "storage-engine.device[0].write_q" is malformed.
 	}
+	// NamespaceStorageMetrics lists the keys we report per
+	// storage-engine.device[ix], storage-engine.file[ix], and
+	// storage-engine.stripe[ix].
 	NamespaceStorageMetrics = []metric{
+		gauge("used_bytes", "used bytes"),
+		gauge("free_wblocks", "free wblocks"),
+		gauge("age", "age"),
 		counter("defrag_reads", "defrag reads"),
 		counter("defrag_writes", "defrag writes"),
 		gauge("shadow_write_q", "shadow write queue"),
@@ -245,11 +251,19 @@ var (
 	}
 )
 
-type nsCollector cmetrics
+type nsCollector struct {
+	metrics    cmetrics
+	devices    cmetrics // per device storage metrics
+	auto       *autoMetrics
+	namespaces *nameFilter
+}
 
-func newNSCollector() nsCollector {
+func newNSCollector(opts collectorOpts) nsCollector {
 	ns := map[string]cmetric{}
 	for _, m := range NamespaceMetrics {
+		if !opts.metrics.allow(promkey(systemNamespace, m.aeroName)) {
+			continue
+		}
 		ns[m.aeroName] = cmetric{
 			typ: m.typ,
 			desc: prometheus.NewDesc(
@@ -260,106 +274,143 @@ func newNSCollector() nsCollector {
 			),
 		}
 	}
+	devices := map[string]cmetric{}
 	for _, m := range NamespaceStorageMetrics {
-		ns[m.aeroName] = cmetric{
+		if !opts.metrics.allow(promkey(systemNamespace, m.aeroName)) {
+			continue
+		}
+		devices[m.aeroName] = cmetric{
 			typ: m.typ,
 			desc: prometheus.NewDesc(
 				promkey(systemNamespace, m.aeroName),
 				m.desc,
-				[]string{"namespace", "mount"},
+				[]string{"namespace", "mount", "type", "index"},
 				nil,
 			),
 		}
 	}
 
-	return ns
+	return nsCollector{
+		metrics: ns,
+		devices: devices,
+		auto: newAutoMetrics(
+			systemNamespace,
+			[]string{"namespace"},
+			append(append([]metric{}, NamespaceMetrics...), NamespaceStorageMetrics...),
+			opts,
+		),
+		namespaces: opts.namespaces,
+	}
 }
 
 func (nc nsCollector) describe(ch chan<- *prometheus.Desc) {
-	for _, s := range nc {
+	for _, s := range nc.metrics {
 		ch <- s.desc
 	}
-}
-
-func (nc nsCollector) parseStorage(s string, d string) (string, error) {
-	// the function remove the storage prefix metrics for each device:
-	// d is storage-engine.device[ix]
-	// s is all storage metrics that has been scraped
-	// storage-engine.device[ix].age -> age
-	// https://www.aerospike.com/docs/reference/metrics/#storage-engine.device[ix].age
-	buf := bytes.Buffer{}
-	for _, l := range strings.Split(s, ";") {
-		for _, v := range strings.Split(l, ":") {
-			kv := strings.SplitN(v, "=", 2)
-			if len(kv) > 1 {
-				if strings.HasPrefix(kv[0], d) {
-					//todo: optimize
-					kv[0] = strings.Replace(kv[0]+".", d, "", 1)
-					kv[0] = strings.Replace(kv[0], ".", "", -1)
-				}
-				buf.WriteString(kv[0] + "=" + kv[1] + ";")
-			}
-		}
-	}
-	r := buf.String()
-	return r, nil
-}
-
-func (nc nsCollector) splitInfo(s string) (string, string, map[string]string) {
-	nsStorageMounts := map[string]string{}
-
-	bufStandardMetrics := bytes.Buffer{}
-	bufStorageMetrics := bytes.Buffer{}
-
-	for _, l := range strings.Split(s, ";") {
-		for _, v := range strings.Split(l, ":") {
-			kv := strings.SplitN(v, "=", 2)
-			if strings.HasPrefix(kv[0], "storage-engine") {
-				bufStorageMetrics.WriteString(v + ";")
-				if strings.HasSuffix(kv[0], "]") {
-					nsStorageMounts[kv[1]] = kv[0]
-				}
-			} else {
-				bufStandardMetrics.WriteString(v + ";")
-			}
-		}
+	for _, s := range nc.devices {
+		ch <- s.desc
 	}
-	nsStandardMetrics := bufStandardMetrics.String()
-	nsStorageMetrics := bufStorageMetrics.String()
-	return nsStorageMetrics, nsStandardMetrics, nsStorageMounts
 }
 
-func (nc nsCollector) collect(conn *as.Connection) ([]prometheus.Metric, error) {
-	info, err := as.RequestInfo(conn, "namespaces")
+func (nc nsCollector) collect(client infoClient) ([]prometheus.Metric, error) {
+	info, err := client.RequestInfo("namespaces")
 	if err != nil {
 		return nil, err
 	}
-	var metrics []prometheus.Metric
+	var nss, cmds []string
 	for _, ns := range strings.Split(info["namespaces"], ";") {
-		nsInfo, err := as.RequestInfo(conn, "namespace/"+ns)
-		if err != nil {
-			return nil, err
+		if !nc.namespaces.allow(ns) {
+			continue
 		}
+		nss = append(nss, ns)
+		cmds = append(cmds, "namespace/"+ns)
+	}
+	nsInfo, err := client.RequestInfo(cmds...)
+	if err != nil {
+		return nil, err
+	}
 
-		nsInfoStorage, nsInfoStandard, nsInfoStorageDevices := nc.splitInfo(nsInfo["namespace/"+ns])
-
+	var metrics []prometheus.Metric
+	for _, ns := range nss {
+		stats, devices := splitStorage(nsInfo["namespace/"+ns])
 		metrics = append(
 			metrics,
-			infoCollect(cmetrics(nc), nsInfoStandard, ns)...,
+			collectStats(nc.metrics, stats, ns)...,
 		)
-
-		for mountName, metricName := range nsInfoStorageDevices {
-			nsInfoStorage, err = nc.parseStorage(nsInfoStorage, metricName)
-
-			if err != nil {
-				return nil, err
-			}
-
+		metrics = append(
+			metrics,
+			nc.auto.collect(nc.metrics, joinInfo(stats), ns)...,
+		)
+		for _, d := range devices {
 			metrics = append(
 				metrics,
-				infoCollect(cmetrics(nc), nsInfoStorage, ns, mountName)...,
+				collectStats(nc.devices, d.stats, ns, d.path, d.typ, d.index)...,
 			)
 		}
 	}
 	return metrics, nil
 }
+
+// storageDevice has the stats of a single storage-engine.device[ix],
+// storage-engine.file[ix], or storage-engine.stripe[ix].
+type storageDevice struct {
+	typ   string // device, file, or stripe
+	index string
+	path  string // not reported by all versions
+	stats map[string]string
+}
+
+var storageKey = regexp.MustCompile(`^storage-engine\.(device|file|stripe)\[(\d+)\](?:\.(.+))?$`)
+
+// splitStorage splits namespace info in the namespace wide stats, and the per
+// device stats. Devices are sorted by type and index. Other storage-engine.*
+// keys are dropped.
+// Per device keys look like this:
+//
+//	storage-engine.device[0]=/dev/sda
+//	storage-engine.device[0].write_q=0
+func splitStorage(info string) (map[string]string, []storageDevice) {
+	var (
+		stats   = map[string]string{}
+		devices = map[string]*storageDevice{}
+	)
+	for k, v := range parseInfo(info) {
+		if !strings.HasPrefix(k, "storage-engine") {
+			stats[k] = v
+			continue
+		}
+		m := storageKey.FindStringSubmatch(k)
+		if m == nil {
+			continue
+		}
+		id := m[1] + "[" + m[2] + "]"
+		d, ok := devices[id]
+		if !ok {
+			d = &storageDevice{
+				typ:   m[1],
+				index: m[2],
+				stats: map[string]string{},
+			}
+			devices[id] = d
+		}
+		if m[3] == "" {
+			d.path = v
+		} else {
+			d.stats[m[3]] = v
+		}
+	}
+
+	res := make([]storageDevice, 0, len(devices))
+	for _, d := range devices {
+		res = append(res, *d)
+	}
+	sort.Slice(res, func(i, j int) bool {
+		if res[i].typ != res[j].typ {
+			return res[i].typ < res[j].typ
+		}
+		a, _ := strconv.Atoi(res[i].index)
+		b, _ := strconv.Atoi(res[j].index)
+		return a < b
+	})
+	return stats, res
+}
@@ -0,0 +1,115 @@
+package main
+
+import (
+	"reflect"
+	"testing"
+
+	"github.com/prometheus/client_golang/prometheus"
+)
+
+func TestSplitStorage(t *testing.T) {
+	type cas struct {
+		info    string
+		stats   map[string]string
+		devices []storageDevice
+	}
+	for n, c := range []cas{
+		{
+			info: "objects=12:storage-engine=device:storage-engine.device[0]=/dev/sda:storage-engine.device[0].used_bytes=1024:storage-engine.device[0].write_q=0:storage-engine.device[1]=/dev/sdb:storage-engine.device[1].used_bytes=2048:storage-engine.device[1].write_q=3:storage-engine.device[1].defrag_q=4",
+			stats: map[string]string{
+				"objects": "12",
+			},
+			devices: []storageDevice{
+				{
+					typ:   "device",
+					index: "0",
+					path:  "/dev/sda",
+					stats: map[string]string{
+						"used_bytes": "1024",
+						"write_q":    "0",
+					},
+				},
+				{
+					typ:   "device",
+					index: "1",
+					path:  "/dev/sdb",
+					stats: map[string]string{
+						"used_bytes": "2048",
+						"write_q":    "3",
+						"defrag_q":   "4",
+					},
+				},
+			},
+		},
+		{
+			// no paths, and more than 10 devices
+			info:  "storage-engine.file[10].age=3;storage-engine.file[2].age=1;storage-engine.stripe[0].write_q=7;storage-engine.file[2].shadow_write_q=0",
+			stats: map[string]string{},
+			devices: []storageDevice{
+				{
+					typ:   "file",
+					index: "2",
+					stats: map[string]string{
+						"age":            "1",
+						"shadow_write_q": "0",
+					},
+				},
+				{
+					typ:   "file",
+					index: "10",
+					stats: map[string]string{
+						"age": "3",
+					},
+				},
+				{
+					typ:   "stripe",
+					index: "0",
+					stats: map[string]string{
+						"write_q": "7",
+					},
+				},
+			},
+		},
+		{
+			info: "objects=1",
+			stats: map[string]string{
+				"objects": "1",
+			},
+			devices: []storageDevice{},
+		},
+	} {
+		stats, devices := splitStorage(c.info)
+		if have, want := stats, c.stats; !reflect.DeepEqual(have, want) {
+			t.Errorf("case %d: have %+v, want %+v", n, have, want)
+		}
+		if have, want := devices, c.devices; !reflect.DeepEqual(have, want) {
+			t.Errorf("case %d: have %+v, want %+v", n, have, want)
+		}
+	}
+}
+
+func TestNSCollectorDevices(t *testing.T) {
+	nc := newNSCollector(collectorOpts{})
+	_, devices := splitStorage("storage-engine.device[0]=/dev/sda:storage-engine.device[0].write_q=1:storage-engine.device[1]=/dev/sdb:storage-engine.device[1].write_q=2")
+	var ms []prometheus.Metric
+	for _, d := range devices {
+		ms = append(ms, collectStats(nc.devices, d.stats, "test", d.path, d.typ, d.index)...)
+	}
+	have := map[string]float64{}
+	for _, mf := range gather(t, ms) {
+		for _, m := range mf.GetMetric() {
+			l := mf.GetName()
+			for _, lp := range m.GetLabel() {
+				l += " " + lp.GetName() + "=" + lp.GetValue()
+			}
+			have[l] = m.GetGauge().GetValue()
+		}
+	}
+	want := map[string]float64{
+		"aerospike_ns_write_q index=0 mount=/dev/sda namespace=test type=device": 1,
+		"aerospike_ns_write_q index=1 mount=/dev/sdb namespace=test type=device": 2,
+	}
+	if !reflect.DeepEqual(have, want) {
+		t.Errorf("have %+v, want %+v", have, want)
+	}
+}
@@ -0,0 +1,44 @@
+package main
+
+import (
+	"github.com/prometheus/client_golang/prometheus"
+)
+
+// nodeInfoCommands are the info commands with the values of the
+// aerospike_node_info labels.
+var nodeInfoCommands = []string{"build", "edition", "node", "cluster-name", "service", "features"}
+
+type nodeInfoCollector struct {
+	desc *prometheus.Desc
+}
+
+func newNodeInfoCollector(opts collectorOpts) nodeInfoCollector {
+	return nodeInfoCollector{
+		desc: prometheus.NewDesc(
+			promkey(systemNode, "info"),
+			"server version and identity of the node. Always 1",
+			[]string{"build", "edition", "node_id", "cluster_name", "service", "features"},
+			nil,
+		),
+	}
+}
+
+func (ic nodeInfoCollector) describe(ch chan<- *prometheus.Desc) {
+	ch <- ic.desc
+}
+
+func (ic nodeInfoCollector) collect(client infoClient) ([]prometheus.Metric, error) {
+	info, err := client.RequestInfo(nodeInfoCommands...)
+	if err != nil {
+		return nil, err
+	}
+	return []prometheus.Metric{
+		prometheus.MustNewConstMetric(
+			ic.desc,
+			prometheus.GaugeValue,
+			1,
+			info["build"], info["edition"], info["node"], info["cluster-name"],
+			info["service"], info["features"],
+		),
+	}, nil
+}
@@ -0,0 +1,127 @@
+package main
+
+import (
+	"fmt"
+	"net/http"
+	"strings"
+	"sync"
+	"time"
+)
+
+// probeIdleTTL is how long we keep the connections and state of a target
+// which isn't probed anymore. Targets can be anything, so they can't be kept
+// forever.
+const probeIdleTTL = 10 * time.Minute
+
+// probeHandler scrapes the node given as the "target" URL parameter. This
+// allows a single asprom to scrape every node in a cluster, like the
+// blackbox exporter does.
+// If allowed is not empty, only targets in that list can be probed.
+type probeHandler struct {
+	dialer  *dialer
+	names   []string // collector names
+	copts   collectorOpts
+	allowed []string
+	opts    scrapeOpts
+
+	mu         sync.Mutex
+	collectors map[string]*probeTarget // per target, so connections are reused
+	closed     bool
+}
+
+type probeTarget struct {
+	c        *asCollector
+	lastUsed time.Time
+}
+
+func newProbeHandler(d *dialer, names []string, copts collectorOpts, allowed []string, opts scrapeOpts) *probeHandler {
+	return &probeHandler{
+		dialer:     d,
+		names:      names,
+		copts:      copts,
+		allowed:    allowed,
+		opts:       opts,
+		collectors: map[string]*probeTarget{},
+	}
+}
+
+func (ph *probeHandler) ServeHTTP(w http.ResponseWriter, r *http.Request) {
+	target := r.URL.Query().Get("target")
+	if target == "" {
+		http.Error(w, "'target' parameter must be specified", http.StatusBadRequest)
+		return
+	}
+	if !targetAllowed(ph.allowed, target) {
+		http.Error(w, fmt.Sprintf("target %q is not allowed", target), http.StatusForbidden)
+		return
+	}
+
+	c := ph.collector(target)
+	if c == nil {
+		// the config was reloaded while this request came in
+		http.Error(w, "exporter is reloading", http.StatusServiceUnavailable)
+		return
+	}
+	ph.opts.handler(c.register).ServeHTTP(w, r)
+}
+
+// collector returns the collector for a target. Collectors of targets which
+// weren't probed for probeIdleTTL are closed. It returns nil after close(),
+// since nothing would close the new collector.
+func (ph *probeHandler) collector(target string) *asCollector {
+	ph.mu.Lock()
+	defer ph.mu.Unlock()
+	if ph.closed {
+		return nil
+	}
+	now := time.Now()
+	for t, pt := range ph.collectors {
+		if now.Sub(pt.lastUsed) > probeIdleTTL {
+			pt.c.close()
+			delete(ph.collectors, t)
+		}
+	}
+	pt, ok := ph.collectors[target]
+	if !ok {
+		pt = &probeTarget{c: newAsCollector(target, ph.dialer, ph.names, ph.copts)}
+		ph.collectors[target] = pt
+	}
+	pt.lastUsed = now
+	return pt.c
+}
+
+// close closes all idle connections, and stops making new collectors.
+func (ph *probeHandler) close() {
+	ph.mu.Lock()
+	defer ph.mu.Unlock()
+	for _, pt := range ph.collectors {
+		pt.c.close()
+	}
+	ph.collectors = map[string]*probeTarget{}
+	ph.closed = true
+}
+
+// targetAllowed is true if target is in the allowlist, or if the allowlist is
+// empty.
+func targetAllowed(allowed []string, target string) bool {
+	if len(allowed) == 0 {
+		return true
+	}
+	for _, a := range allowed {
+		if a == target {
+			return true
+		}
+	}
+	return false
+}
+
+// parseTargets splits a comma separated list of targets.
+func parseTargets(s string) []string {
+	var ts []string
+	for _, t := range strings.Split(s, ",") {
+		if t = strings.TrimSpace(t); t != "" {
+			ts = append(ts, t)
+		}
+	}
+	return ts
+}
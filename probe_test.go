@@ -0,0 +1,125 @@
+package main
+
+import (
+	"net/http"
+	"net/http/httptest"
+	"reflect"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/alicebob/asprom/internal/fakeaero"
+)
+
+func TestProbeHandler(t *testing.T) {
+	type cas struct {
+		url     string
+		allowed []string
+		code    int
+		body    string
+	}
+	for n, c := range []cas{
+		{
+			url:  "/probe",
+			code: http.StatusBadRequest,
+			body: "'target' parameter must be specified",
+		},
+		{
+			url:     "/probe?target=10.0.0.1:3000",
+			allowed: []string{"10.0.0.2:3000", "10.0.0.3:3000"},
+			code:    http.StatusForbidden,
+			body:    `target "10.0.0.1:3000" is not allowed`,
+		},
+		{
+			// nothing listens on port 1
+			url:     "/probe?target=127.0.0.1:1",
+			allowed: []string{"127.0.0.1:1"},
+			code:    http.StatusOK,
+			body:    "aerospike_node_up 0",
+		},
+	} {
+		w := httptest.NewRecorder()
+		newProbeHandler(newDialer("", "", nil, "", false), collectorNames(), collectorOpts{}, c.allowed, scrapeOpts{}).ServeHTTP(w, httptest.NewRequest("GET", c.url, nil))
+		if have, want := w.Code, c.code; have != want {
+			t.Errorf("case %d: have %d, want %d", n, have, want)
+		}
+		if have, want := w.Body.String(), c.body; !strings.Contains(have, want) {
+			t.Errorf("case %d: have %q, want %q", n, have, want)
+		}
+	}
+}
+
+func TestProbeNotAllowed(t *testing.T) {
+	s, err := fakeaero.New()
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer s.Close()
+	if err := s.AddUser("admin", "s3cret"); err != nil {
+		t.Fatal(err)
+	}
+
+	ph := newProbeHandler(newDialer("admin", "s3cret", nil, "", false), collectorNames(), collectorOpts{}, []string{"10.0.0.1:3000"}, scrapeOpts{})
+	defer ph.close()
+	w := httptest.NewRecorder()
+	ph.ServeHTTP(w, httptest.NewRequest("GET", "/probe?target="+s.Addr(), nil))
+	if have, want := w.Code, http.StatusForbidden; have != want {
+		t.Errorf("have %d, want %d", have, want)
+	}
+	if have, want := len(ph.collectors), 0; have != want {
+		t.Errorf("have %d collectors, want %d", have, want)
+	}
+	if have, want := s.Accepted(), 0; have != want {
+		t.Errorf("have %d connections, want %d", have, want)
+	}
+}
+
+func TestParseTargets(t *testing.T) {
+	for k, v := range map[string][]string{
+		"":                         nil,
+		"a:3000":                   {"a:3000"},
+		"a:3000, b:3000,,c:3000 ,": {"a:3000", "b:3000", "c:3000"},
+	} {
+		if have, want := parseTargets(k), v; !reflect.DeepEqual(have, want) {
+			t.Errorf("have %+v, want %+v", have, want)
+		}
+	}
+}
+
+func TestProbeEviction(t *testing.T) {
+	ph := newProbeHandler(newDialer("", "", nil, "", false), collectorNames(), collectorOpts{}, nil, scrapeOpts{})
+	defer ph.close()
+	a := ph.collector("10.0.0.1:3000")
+	if ph.collector("10.0.0.1:3000") != a {
+		t.Errorf("expected the same collector")
+	}
+	ph.collectors["10.0.0.1:3000"].lastUsed = time.Now().Add(-probeIdleTTL - time.Second)
+	ph.collector("10.0.0.2:3000")
+	if _, ok := ph.collectors["10.0.0.1:3000"]; ok {
+		t.Errorf("idle target not evicted")
+	}
+	if !a.pool.closed {
+		t.Errorf("pool of an evicted target not closed")
+	}
+	if have, want := len(ph.collectors), 1; have != want {
+		t.Errorf("have %d, want %d", have, want)
+	}
+}
+
+func TestProbeClosed(t *testing.T) {
+	ph := newProbeHandler(newDialer("", "", nil, "", false), collectorNames(), collectorOpts{}, nil, scrapeOpts{})
+	a := ph.collector("10.0.0.1:3000")
+	ph.close()
+	if !a.pool.closed {
+		t.Errorf("pool not closed")
+	}
+	if c := ph.collector("10.0.0.2:3000"); c != nil {
+		t.Errorf("new collector after close")
+	}
+
+	w := httptest.NewRecorder()
+	ph.ServeHTTP(w, httptest.NewRequest("GET", "/probe?target=10.0.0.1:3000", nil))
+	if have, want := w.Code, http.StatusServiceUnavailable; have != want {
+		t.Errorf("have %d, want %d", have, want)
+	}
+}
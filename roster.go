@@ -0,0 +1,224 @@
+package main
+
+import (
+	"fmt"
+	"strings"
+
+	"github.com/prometheus/client_golang/prometheus"
+)
+
+// rosterCollector reports the roster of strong consistency namespaces, and
+// the rack membership of all namespaces.
+type rosterCollector struct {
+	size         *prometheus.Desc
+	pendingSize  *prometheus.Desc
+	observedSize *prometheus.Desc
+	notInRoster  *prometheus.Desc
+	mismatch     *prometheus.Desc
+	rackNodes    *prometheus.Desc
+	namespaces   *nameFilter
+}
+
+func newRosterCollector(opts collectorOpts) rosterCollector {
+	ns := []string{"namespace"}
+	return rosterCollector{
+		size:         prometheus.NewDesc(promkey(systemRoster, "size"), "number of nodes in the roster", ns, nil),
+		pendingSize:  prometheus.NewDesc(promkey(systemRoster, "pending_size"), "number of nodes in the pending roster", ns, nil),
+		observedSize: prometheus.NewDesc(promkey(systemRoster, "observed_size"), "number of observed nodes", ns, nil),
+		notInRoster:  prometheus.NewDesc(promkey(systemRoster, "observed_not_in_roster"), "number of observed nodes which are not in the roster", ns, nil),
+		mismatch:     prometheus.NewDesc(promkey(systemRoster, "mismatch"), "1 if the observed nodes or the pending roster differ from the roster", ns, nil),
+		rackNodes:    prometheus.NewDesc(promkey(systemRack, "nodes"), "number of nodes in the rack", []string{"namespace", "rack"}, nil),
+		namespaces:   opts.namespaces,
+	}
+}
+
+func (rc rosterCollector) describe(ch chan<- *prometheus.Desc) {
+	ch <- rc.size
+	ch <- rc.pendingSize
+	ch <- rc.observedSize
+	ch <- rc.notInRoster
+	ch <- rc.mismatch
+	ch <- rc.rackNodes
+}
+
+func (rc rosterCollector) collect(client infoClient) ([]prometheus.Metric, error) {
+	info, err := client.RequestInfo("namespaces", "racks:")
+	if err != nil {
+		return nil, err
+	}
+	var (
+		metrics []prometheus.Metric
+		cmds    []string
+	)
+	for _, ns := range strings.Split(info["namespaces"], ";") {
+		if ns == "" || !rc.namespaces.allow(ns) {
+			continue
+		}
+		cmds = append(cmds, "roster:namespace="+ns)
+	}
+
+	racks, err := parseRacks(info["racks:"])
+	if err != nil {
+		return nil, err
+	}
+	for ns, rs := range racks {
+		if !rc.namespaces.allow(ns) {
+			continue
+		}
+		for rack, nodes := range rs {
+			metrics = append(
+				metrics,
+				prometheus.MustNewConstMetric(rc.rackNodes, prometheus.GaugeValue, float64(len(nodes)), ns, rack),
+			)
+		}
+	}
+
+	if len(cmds) == 0 {
+		return metrics, nil
+	}
+	rosters, err := client.RequestInfo(cmds...)
+	if err != nil {
+		return nil, err
+	}
+	for _, cmd := range cmds {
+		ns := strings.TrimPrefix(cmd, "roster:namespace=")
+		r, ok := parseRoster(rosters[cmd])
+		if !ok {
+			// not a strong consistency namespace, or roster: isn't supported
+			continue
+		}
+		value := func(d *prometheus.Desc, v int) prometheus.Metric {
+			return prometheus.MustNewConstMetric(d, prometheus.GaugeValue, float64(v), ns)
+		}
+		mismatch := 0
+		if r.mismatch() {
+			mismatch = 1
+		}
+		metrics = append(
+			metrics,
+			value(rc.size, len(r.roster)),
+			value(rc.pendingSize, len(r.pending)),
+			value(rc.observedSize, len(r.observed)),
+			value(rc.notInRoster, len(r.notInRoster())),
+			value(rc.mismatch, mismatch),
+		)
+	}
+	return metrics, nil
+}
+
+// roster is a parsed roster:namespace=<ns> response. Nodes are reported as
+// "<node id>@<rack id>" if racks are used.
+type roster struct {
+	roster   []string
+	pending  []string
+	observed []string
+}
+
+// parseRoster parses a roster: response, which looks like:
+//
+//	roster=BB9030011AC4202,BB9020011AC4202:pending_roster=BB9030011AC4202,BB9020011AC4202:observed_nodes=BB9030011AC4202
+//
+// It returns false if the namespace has no roster.
+func parseRoster(s string) (roster, bool) {
+	if s == "" || strings.HasPrefix(strings.ToLower(s), "error") {
+		return roster{}, false
+	}
+	kv := parseInfo(s)
+	nodes := func(k string) []string {
+		v := kv[k]
+		if v == "" || v == "null" {
+			return nil
+		}
+		return strings.Split(v, ",")
+	}
+	r := roster{
+		roster:   nodes("roster"),
+		pending:  nodes("pending_roster"),
+		observed: nodes("observed_nodes"),
+	}
+	if r.roster == nil && r.pending == nil {
+		return roster{}, false
+	}
+	return r, true
+}
+
+// notInRoster returns the observed nodes which aren't in the roster.
+func (r roster) notInRoster() []string {
+	in := map[string]bool{}
+	for _, n := range r.roster {
+		in[n] = true
+	}
+	var res []string
+	for _, n := range r.observed {
+		if !in[n] {
+			res = append(res, n)
+		}
+	}
+	return res
+}
+
+// mismatch is true if the observed nodes or the pending roster aren't the
+// same as the roster.
+func (r roster) mismatch() bool {
+	return !sameNodes(r.roster, r.observed) || !sameNodes(r.roster, r.pending)
+}
+
+func sameNodes(a, b []string) bool {
+	if len(a) != len(b) {
+		return false
+	}
+	in := map[string]bool{}
+	for _, n := range a {
+		in[n] = true
+	}
+	for _, n := range b {
+		if !in[n] {
+			return false
+		}
+	}
+	return true
+}
+
+// parseRacks parses a racks: response, which looks like:
+//
+//	ns=test:rack_1=BB9030011AC4202:rack_2=BB9020011AC4202,BB9010011AC4202;ns=bar:rack_0=BB9030011AC4202
+//
+// It returns namespace -> rack id -> nodes. Roster racks ("roster_rack_1")
+// are ignored.
+func parseRacks(s string) (map[string]map[string][]string, error) {
+	res := map[string]map[string][]string{}
+	if strings.HasPrefix(strings.ToLower(s), "error") {
+		// racks: isn't supported
+		return res, nil
+	}
+	for _, line := range strings.Split(s, ";") {
+		if line == "" {
+			continue
+		}
+		var (
+			ns    string
+			racks = map[string][]string{}
+		)
+		for _, f := range strings.Split(line, ":") {
+			kv := strings.SplitN(f, "=", 2)
+			if len(kv) != 2 {
+				return nil, fmt.Errorf("invalid racks field %q", f)
+			}
+			switch {
+			case kv[0] == "ns":
+				ns = kv[1]
+			case strings.HasPrefix(kv[0], "rack_"):
+				var nodes []string
+				if kv[1] != "" {
+					nodes = strings.Split(kv[1], ",")
+				}
+				racks[strings.TrimPrefix(kv[0], "rack_")] = nodes
+			}
+		}
+		if ns == "" {
+			return nil, fmt.Errorf("no namespace in racks line %q", line)
+		}
+		res[ns] = racks
+	}
+	return res, nil
+}
@@ -0,0 +1,129 @@
+package main
+
+import (
+	"reflect"
+	"testing"
+)
+
+func TestParseRoster(t *testing.T) {
+	type cas struct {
+		roster      string
+		ok          bool
+		want        roster
+		notInRoster []string
+		mismatch    bool
+	}
+	for n, c := range []cas{
+		{
+			roster: "roster=A@1,B@1:pending_roster=A@1,B@1:observed_nodes=B@1,A@1",
+			ok:     true,
+			want: roster{
+				roster:   []string{"A@1", "B@1"},
+				pending:  []string{"A@1", "B@1"},
+				observed: []string{"B@1", "A@1"},
+			},
+		},
+		{
+			// C joined, but isn't in the roster yet
+			roster: "roster=A,B:pending_roster=A,B,C:observed_nodes=A,B,C",
+			ok:     true,
+			want: roster{
+				roster:   []string{"A", "B"},
+				pending:  []string{"A", "B", "C"},
+				observed: []string{"A", "B", "C"},
+			},
+			notInRoster: []string{"C"},
+			mismatch:    true,
+		},
+		{
+			// B is down
+			roster: "roster=A,B:pending_roster=A,B:observed_nodes=A",
+			ok:     true,
+			want: roster{
+				roster:   []string{"A", "B"},
+				pending:  []string{"A", "B"},
+				observed: []string{"A"},
+			},
+			mismatch: true,
+		},
+		{
+			// AP namespace
+			roster: "roster=null:pending_roster=null:observed_nodes=A,B",
+		},
+		{
+			roster: "ERROR::unknown command",
+		},
+		{
+			roster: "",
+		},
+	} {
+		have, ok := parseRoster(c.roster)
+		if have, want := ok, c.ok; have != want {
+			t.Errorf("case %d: have %t, want %t", n, have, want)
+			continue
+		}
+		if want := c.want; !reflect.DeepEqual(have, want) {
+			t.Errorf("case %d: have %+v, want %+v", n, have, want)
+		}
+		if have, want := have.notInRoster(), c.notInRoster; !reflect.DeepEqual(have, want) {
+			t.Errorf("case %d: have %+v, want %+v", n, have, want)
+		}
+		if have, want := have.mismatch(), c.mismatch; ok && have != want {
+			t.Errorf("case %d: have %t, want %t", n, have, want)
+		}
+	}
+}
+
+func TestParseRacks(t *testing.T) {
+	type cas struct {
+		racks string
+		error string
+		want  map[string]map[string][]string
+	}
+	for n, c := range []cas{
+		{
+			racks: "ns=test:rack_1=A,B:rack_2=C:roster_rack_1=A;ns=bar:rack_0=A,B,C",
+			want: map[string]map[string][]string{
+				"test": {
+					"1": {"A", "B"},
+					"2": {"C"},
+				},
+				"bar": {
+					"0": {"A", "B", "C"},
+				},
+			},
+		},
+		{
+			racks: "",
+			want:  map[string]map[string][]string{},
+		},
+		{
+			racks: "ERROR::unknown command",
+			want:  map[string]map[string][]string{},
+		},
+		{
+			racks: "rack_1=A",
+			error: `no namespace in racks line "rack_1=A"`,
+		},
+		{
+			racks: "ns=test:foo",
+			error: `invalid racks field "foo"`,
+		},
+	} {
+		have, err := parseRacks(c.racks)
+		haveerr := ""
+		if err != nil {
+			haveerr = err.Error()
+		}
+		if have, want := haveerr, c.error; have != want {
+			t.Errorf("case %d: have %q, want %q", n, have, want)
+			continue
+		}
+		if err != nil {
+			continue
+		}
+		if want := c.want; !reflect.DeepEqual(have, want) {
+			t.Errorf("case %d: have %+v, want %+v", n, have, want)
+		}
+	}
+}
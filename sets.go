@@ -3,7 +3,6 @@ package main
 import (
 	"strings"
 
-	as "github.com/aerospike/aerospike-client-go"
 	"github.com/prometheus/client_golang/prometheus"
 )
 
@@ -19,11 +18,19 @@ var (
 	}
 )
 
-type setCollector cmetrics
+type setCollector struct {
+	metrics    cmetrics
+	auto       *autoMetrics
+	namespaces *nameFilter
+	sets       *nameFilter
+}
 
-func newSetCollector() setCollector {
+func newSetCollector(opts collectorOpts) setCollector {
 	set := map[string]cmetric{}
 	for _, m := range SetMetrics {
+		if !opts.metrics.allow(promkey(systemSet, m.aeroName)) {
+			continue
+		}
 		set[m.aeroName] = cmetric{
 			typ: m.typ,
 			desc: prometheus.NewDesc(
@@ -34,18 +41,23 @@ func newSetCollector() setCollector {
 			),
 		}
 	}
-	return set
+	return setCollector{
+		metrics:    set,
+		auto:       newAutoMetrics(systemSet, []string{"namespace", "set"}, SetMetrics, opts),
+		namespaces: opts.namespaces,
+		sets:       opts.sets,
+	}
 }
 
 func (setc setCollector) describe(ch chan<- *prometheus.Desc) {
-	for _, s := range setc {
+	for _, s := range setc.metrics {
 		ch <- s.desc
 	}
 }
 
-func (setc setCollector) collect(conn *as.Connection) ([]prometheus.Metric, error) {
+func (setc setCollector) collect(client infoClient) ([]prometheus.Metric, error) {
 	var metrics []prometheus.Metric
-	info, err := as.RequestInfo(conn, "sets")
+	info, err := client.RequestInfo("sets")
 	if err != nil {
 		return nil, err
 	}
@@ -54,9 +66,16 @@ func (setc setCollector) collect(conn *as.Connection) ([]prometheus.Metric, erro
 			continue
 		}
 		setStats := parseInfo(setInfo)
+		if !setc.namespaces.allow(setStats["ns"]) || !setc.sets.allow(setStats["set"]) {
+			continue
+		}
+		metrics = append(
+			metrics,
+			infoCollect(setc.metrics, setInfo, setStats["ns"], setStats["set"])...,
+		)
 		metrics = append(
 			metrics,
-			infoCollect(cmetrics(setc), setInfo, setStats["ns"], setStats["set"])...,
+			setc.auto.collect(setc.metrics, setInfo, setStats["ns"], setStats["set"])...,
 		)
 	}
 	return metrics, nil
@@ -3,7 +3,6 @@ package main
 import (
   "strings"
 
-  as "github.com/aerospike/aerospike-client-go"
   "github.com/prometheus/client_golang/prometheus"
 )
 
@@ -36,39 +35,96 @@ var (
     gauge("query_lookup_avg_rec_count", "query_lookup_avg_rec_count"),
     gauge("query_lookup_avg_record_size", "query_lookup_avg_record_size"),
   }
+
+  // Sindex6Metrics lists the keys we report for the secondary indexes
+  // which were rewritten in Aerospike 6.0.
+  Sindex6Metrics = []metric{
+    gauge("entries", "entries"),
+    gauge("entries_per_bval", "entries_per_bval"),
+    gauge("entries_per_rec", "entries_per_rec"),
+    gauge("memory_used", "memory_used"),
+    gauge("load_pct", "load_pct"),
+    gauge("load_time", "load_time"),
+    counter("gc_cleaned", "gc_cleaned"),
+  }
 )
 
-type sindexCollector cmetrics
+var sindexLabels = []string{"namespace", "sindex", "set", "bin", "type", "indextype", "path"}
+
+type sindexCollector struct {
+  metrics    cmetrics // before 6.0
+  metrics6   cmetrics
+  auto       *autoMetrics
+  namespaces *nameFilter
+  sets       *nameFilter
+  sindexes   *nameFilter
+}
 
-func newSindexCollector() sindexCollector {
-  sindex := map[string]cmetric {}
-  for _, m := range SindexMetrics {
-    sindex[m.aeroName] = cmetric{
-      typ: m.typ,
-      desc: prometheus.NewDesc(
-        promkey(secondaryIndex, m.aeroName),
-        m.desc,
-        []string{"namespace", "sindex", "set", "bin", "type", "indextype", "path"},
-        nil,
-      ),
+func newSindexCollector(opts collectorOpts) sindexCollector {
+  // some keys are in both lists, so they share their descs
+  descs := map[string]*prometheus.Desc{}
+  mk := func(ms []metric) cmetrics {
+    sindex := map[string]cmetric {}
+    for _, m := range ms {
+      if !opts.metrics.allow(promkey(secondaryIndex, m.aeroName)) {
+        continue
+      }
+      d, ok := descs[m.aeroName]
+      if !ok {
+        d = prometheus.NewDesc(
+          promkey(secondaryIndex, m.aeroName),
+          m.desc,
+          sindexLabels,
+          nil,
+        )
+        descs[m.aeroName] = d
+      }
+      sindex[m.aeroName] = cmetric{
+        typ:  m.typ,
+        desc: d,
+      }
     }
+    return sindex
+  }
+  return sindexCollector{
+    metrics:    mk(SindexMetrics),
+    metrics6:   mk(Sindex6Metrics),
+    auto:       newAutoMetrics(secondaryIndex, sindexLabels, append(append([]metric{}, SindexMetrics...), Sindex6Metrics...), opts),
+    namespaces: opts.namespaces,
+    sets:       opts.sets,
+    sindexes:   opts.sindexes,
   }
-  return sindex
 }
 
 func (sindexc sindexCollector) describe(ch chan<- *prometheus.Desc) {
-  for _, s := range sindexc {
+  for _, s := range sindexc.metrics {
     ch <- s.desc
   }
+  for k, s := range sindexc.metrics6 {
+    if _, ok := sindexc.metrics[k]; !ok {
+      ch <- s.desc
+    }
+  }
 }
 
-func (sic sindexCollector) collect(conn *as.Connection) ([]prometheus.Metric, error) {
-  info, err := as.RequestInfo(conn, "sindex")
+func (sic sindexCollector) collect(client infoClient) ([]prometheus.Metric, error) {
+  info, err := client.RequestInfo("build", "sindex")
+  if err != nil {
+    return nil, err
+  }
+  v, err := parseVersion(info["build"])
   if err != nil {
     return nil, err
   }
+  metrics := sic.metrics
+  if v.atLeast(6) {
+    metrics = sic.metrics6
+  }
 
-  var metrics []prometheus.Metric
+  var (
+    cmds   []string
+    labels [][]string // by cmd
+  )
   for _, sindexInfo := range strings.Split(info["sindex"], ";") {
     if sindexInfo == "" {
       continue
@@ -76,25 +132,39 @@ func (sic sindexCollector) collect(conn *as.Connection) ([]prometheus.Metric, er
     sindexStats := parseInfo(sindexInfo)
     ns := sindexStats["ns"]
     sindexName := sindexStats["indexname"]
-    sindexDetails, err := as.RequestInfo(conn, "sindex/"+ns+"/"+sindexName)
-    if err != nil {
-      return nil, err
+    if !sic.namespaces.allow(ns) || !sic.sets.allow(sindexStats["set"]) || !sic.sindexes.allow(sindexName) {
+      continue
     }
+    cmds = append(cmds, "sindex/"+ns+"/"+sindexName)
+    labels = append(labels, []string{
+      ns,
+      sindexName,
+      sindexStats["set"],
+      sindexStats["bin"],
+      sindexStats["type"],
+      sindexStats["indextype"],
+      sindexStats["path"],
+    })
+  }
+  if len(cmds) == 0 {
+    return nil, nil
+  }
 
-    metrics = append(
-      metrics,
-      infoCollect(
-        cmetrics(sic),
-        sindexDetails["sindex/"+ns+"/"+sindexName],
-        ns,
-        sindexName,
-        sindexStats["set"],
-        sindexStats["bin"],
-        sindexStats["type"],
-        sindexStats["indextype"],
-        sindexStats["path"],
-      )...,
+  // all indexes in a single request
+  details, err := client.RequestInfo(cmds...)
+  if err != nil {
+    return nil, err
+  }
+  var res []prometheus.Metric
+  for i, cmd := range cmds {
+    res = append(
+      res,
+      infoCollect(metrics, details[cmd], labels[i]...)...,
+    )
+    res = append(
+      res,
+      sic.auto.collect(metrics, details[cmd], labels[i]...)...,
     )
   }
-  return metrics, nil
+  return res, nil
 }
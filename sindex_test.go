@@ -0,0 +1,67 @@
+package main
+
+import (
+	"reflect"
+	"testing"
+)
+
+func TestSindexCollector(t *testing.T) {
+	type cas struct {
+		build   string
+		sindex  string
+		details string
+		want    map[string]float64 // by metric name
+	}
+	for _, c := range []cas{
+		{
+			build:   "5.6.0.7",
+			sindex:  "ns=test:set=demo:indexname=idx_age:num_bins=1:bin=age:type=NUMERIC:indextype=NONE:path=age:sync_state=synced:state=RW",
+			details: "keys=62;entries=1000;ibtr_memory_used=18432;nbtr_memory_used=36900;si_accounted_memory=55332;load_pct=100;loadtime=3;memory_used=55332",
+			want: map[string]float64{
+				"aerospike_sindex_keys":                62,
+				"aerospike_sindex_entries":             1000,
+				"aerospike_sindex_ibtr_memory_used":    18432,
+				"aerospike_sindex_nbtr_memory_used":    36900,
+				"aerospike_sindex_si_accounted_memory": 55332,
+				"aerospike_sindex_load_pct":            100,
+				"aerospike_sindex_loadtime":            3,
+			},
+		},
+		{
+			build:   "6.0.0.1",
+			sindex:  "ns=test:indexname=idx_age:set=demo:bin=age:type=numeric:indextype=default:context=NULL:state=RW",
+			details: "entries=1000;used_bytes=55332;entries_per_bval=16;entries_per_rec=1;memory_used=55332;load_pct=100;load_time=3;stat_gc_recs=0;gc_cleaned=12;keys=62",
+			want: map[string]float64{
+				"aerospike_sindex_entries":          1000,
+				"aerospike_sindex_entries_per_bval": 16,
+				"aerospike_sindex_entries_per_rec":  1,
+				"aerospike_sindex_memory_used":      55332,
+				"aerospike_sindex_load_pct":         100,
+				"aerospike_sindex_load_time":        3,
+				"aerospike_sindex_gc_cleaned":       12,
+			},
+		},
+	} {
+		f := &fakeClient{responses: map[string]string{
+			"build":               c.build,
+			"sindex":              c.sindex,
+			"sindex/test/idx_age": c.details,
+		}}
+		ms, err := newSindexCollector(collectorOpts{}).collect(batchClient{f})
+		if err != nil {
+			t.Fatalf("%s: %s", c.build, err)
+		}
+		have := map[string]float64{}
+		for _, mf := range gather(t, ms) {
+			m := mf.GetMetric()[0]
+			if v := m.GetGauge(); v != nil {
+				have[mf.GetName()] = v.GetValue()
+			} else {
+				have[mf.GetName()] = m.GetCounter().GetValue()
+			}
+		}
+		if !reflect.DeepEqual(have, c.want) {
+			t.Errorf("%s: have %+v, want %+v", c.build, have, c.want)
+		}
+	}
+}
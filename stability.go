@@ -0,0 +1,156 @@
+package main
+
+import (
+	"hash/fnv"
+	"strconv"
+	"strings"
+	"sync"
+
+	"github.com/prometheus/client_golang/prometheus"
+)
+
+// stabilityCollector reports whether the node agrees with the rest of the
+// cluster. Compare aerospike_cluster_key_hash between nodes to find a split
+// brain.
+type stabilityCollector struct {
+	stable     *prometheus.Desc
+	nsStable   *prometheus.Desc
+	generation *prometheus.Desc
+	integrity  *prometheus.Desc
+	isMember   *prometheus.Desc
+	principal  *prometheus.Desc
+	keyHash    *prometheus.Desc
+	keyChanges *prometheus.Desc
+	namespaces *nameFilter
+	size       int // expected cluster size. 0 doesn't check the size.
+
+	mu      sync.Mutex
+	key     string // last seen cluster key
+	changes float64
+}
+
+func newStabilityCollector(opts collectorOpts) *stabilityCollector {
+	desc := func(name, help string, labels ...string) *prometheus.Desc {
+		return prometheus.NewDesc(promkey(systemCluster, name), help, labels, nil)
+	}
+	return &stabilityCollector{
+		stable:     desc("stable", "1 if cluster-stable: says the cluster has no migrations, and the configured cluster size"),
+		nsStable:   desc("namespace_stable", "1 if cluster-stable: says the cluster has no migrations for the namespace, and the configured cluster size", "namespace"),
+		generation: desc("generation", "cluster generation"),
+		integrity:  desc("integrity", "1 if the cluster has integrity"),
+		isMember:   desc("is_member", "1 if the node is a member of the cluster"),
+		principal:  desc("principal", "the principal node of the cluster. Always 1", "principal"),
+		keyHash:    desc("key_hash", "hash of the cluster key. All nodes should have the same value"),
+		keyChanges: desc("key_changes_total", "number of times asprom saw the cluster key change"),
+		namespaces: opts.namespaces,
+		size:       opts.clusterSize,
+	}
+}
+
+func (sc *stabilityCollector) describe(ch chan<- *prometheus.Desc) {
+	ch <- sc.stable
+	ch <- sc.nsStable
+	ch <- sc.generation
+	ch <- sc.integrity
+	ch <- sc.isMember
+	ch <- sc.principal
+	ch <- sc.keyHash
+	ch <- sc.keyChanges
+}
+
+func (sc *stabilityCollector) collect(client infoClient) ([]prometheus.Metric, error) {
+	info, err := client.RequestInfo("statistics", "cluster-generation", "namespaces")
+	if err != nil {
+		return nil, err
+	}
+	stats := parseInfo(info["statistics"])
+
+	var metrics []prometheus.Metric
+	add := func(d *prometheus.Desc, v float64, labels ...string) {
+		metrics = append(metrics, prometheus.MustNewConstMetric(d, prometheus.GaugeValue, v, labels...))
+	}
+	if g, err := strconv.ParseFloat(info["cluster-generation"], 64); err == nil {
+		add(sc.generation, g)
+	}
+	for d, k := range map[*prometheus.Desc]string{
+		sc.integrity: "cluster_integrity",
+		sc.isMember:  "cluster_is_member",
+	} {
+		if v, err := parseFloatOrBool(stats[k]); err == nil {
+			add(d, v)
+		}
+	}
+	if p := stats["cluster_principal"]; p != "" {
+		add(sc.principal, 1, p)
+	}
+	if key, ok := stats["cluster_key"]; ok {
+		add(sc.keyHash, hashKey(key))
+		metrics = append(
+			metrics,
+			prometheus.MustNewConstMetric(sc.keyChanges, prometheus.CounterValue, sc.observeKey(key)),
+		)
+	}
+
+	// The node's own cluster_size can't be used as size, since a node
+	// always agrees with itself.
+	var args []string
+	if sc.size > 0 {
+		args = append(args, "size="+strconv.Itoa(sc.size))
+	}
+	cmds := []string{"cluster-stable:" + strings.Join(args, ";")}
+	for _, ns := range strings.Split(info["namespaces"], ";") {
+		if ns == "" || !sc.namespaces.allow(ns) {
+			continue
+		}
+		cmds = append(cmds, "cluster-stable:"+strings.Join(append(args, "namespace="+ns), ";"))
+	}
+	stable, err := client.RequestInfo(cmds...)
+	if err != nil {
+		return nil, err
+	}
+	for _, c := range cmds {
+		v, ok := parseClusterStable(stable[c])
+		if !ok {
+			continue
+		}
+		if i := strings.Index(c, "namespace="); i >= 0 {
+			add(sc.nsStable, v, c[i+len("namespace="):])
+		} else {
+			add(sc.stable, v)
+		}
+	}
+	return metrics, nil
+}
+
+// observeKey returns the number of key changes so far.
+func (sc *stabilityCollector) observeKey(key string) float64 {
+	sc.mu.Lock()
+	defer sc.mu.Unlock()
+	if sc.key != "" && key != sc.key {
+		sc.changes++
+	}
+	sc.key = key
+	return sc.changes
+}
+
+// hashKey makes a number from the cluster key, which is too big to fit in a
+// float64 without losing precision.
+func hashKey(key string) float64 {
+	h := fnv.New32a()
+	h.Write([]byte(key))
+	return float64(h.Sum32())
+}
+
+// parseClusterStable parses a cluster-stable: response. That's the cluster key
+// if the cluster is stable, and an error if it's not. It returns false if the
+// server doesn't support cluster-stable:.
+func parseClusterStable(s string) (float64, bool) {
+	switch {
+	case s == "":
+		return 0, false
+	case strings.HasPrefix(strings.ToLower(s), "error"):
+		return 0, true
+	default:
+		return 1, true
+	}
+}
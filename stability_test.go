@@ -0,0 +1,94 @@
+package main
+
+import (
+	"path/filepath"
+	"strings"
+	"testing"
+)
+
+func TestParseClusterStable(t *testing.T) {
+	type cas struct {
+		stable string
+		want   float64
+		ok     bool
+	}
+	for n, c := range []cas{
+		{stable: "ECADEA1AFD4E", want: 1, ok: true},
+		{stable: "ERROR::cluster-not-specified-size", want: 0, ok: true},
+		{stable: "ERROR::unstable-cluster", want: 0, ok: true},
+		{stable: "", want: 0, ok: false},
+	} {
+		have, ok := parseClusterStable(c.stable)
+		if have, want := ok, c.ok; have != want {
+			t.Errorf("case %d: have %t, want %t", n, have, want)
+		}
+		if want := c.want; have != want {
+			t.Errorf("case %d: have %v, want %v", n, have, want)
+		}
+	}
+}
+
+func TestObserveKey(t *testing.T) {
+	sc := newStabilityCollector(collectorOpts{})
+	for n, c := range []struct {
+		key  string
+		want float64
+	}{
+		{"C0758EC6A81F", 0},
+		{"C0758EC6A81F", 0},
+		{"ECADEA1AFD4E", 1},
+		{"ECADEA1AFD4E", 1},
+		{"C0758EC6A81F", 2},
+	} {
+		if have, want := sc.observeKey(c.key), c.want; have != want {
+			t.Errorf("case %d: have %v, want %v", n, have, want)
+		}
+	}
+	if hashKey("C0758EC6A81F") == hashKey("ECADEA1AFD4E") {
+		t.Errorf("same hash for different keys")
+	}
+}
+
+func TestStabilityClusterSize(t *testing.T) {
+	type cas struct {
+		size int
+		want []string
+	}
+	for _, c := range []cas{
+		{
+			// no size check
+			size: 0,
+			want: []string{
+				`aerospike_cluster_stable 1`,
+				`aerospike_cluster_namespace_stable{namespace="test"} 1`,
+			},
+		},
+		{
+			size: 1,
+			want: []string{
+				`aerospike_cluster_stable 1`,
+				`aerospike_cluster_namespace_stable{namespace="test"} 1`,
+			},
+		},
+		{
+			// the node is on its own, but we expect 3 nodes
+			size: 3,
+			want: []string{
+				`aerospike_cluster_stable 0`,
+				`aerospike_cluster_namespace_stable{namespace="test"} 0`,
+			},
+		},
+	} {
+		client := loadFakeClient(t, filepath.Join("testdata", "4.9", "asinfo.txt"))
+		ms, err := newStabilityCollector(collectorOpts{clusterSize: c.size}).collect(client)
+		if err != nil {
+			t.Fatal(err)
+		}
+		have := exposition(t, gather(t, ms))
+		for _, want := range c.want {
+			if !strings.Contains(have, want+"\n") {
+				t.Errorf("size %d: no %q in:\n%s", c.size, want, have)
+			}
+		}
+	}
+}
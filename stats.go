@@ -1,7 +1,6 @@
 package main
 
 import (
-	as "github.com/aerospike/aerospike-client-go"
 	"github.com/prometheus/client_golang/prometheus"
 )
 
@@ -124,11 +123,17 @@ var (
 	}
 )
 
-type statsCollector cmetrics
+type statsCollector struct {
+	metrics cmetrics
+	auto    *autoMetrics
+}
 
-func newStatsCollector() statsCollector {
+func newStatsCollector(opts collectorOpts) statsCollector {
 	smetrics := map[string]cmetric{}
 	for _, m := range StatsMetrics {
+		if !opts.metrics.allow(promkey(systemNode, m.aeroName)) {
+			continue
+		}
 		smetrics[m.aeroName] = cmetric{
 			desc: prometheus.NewDesc(
 				promkey(systemNode, m.aeroName),
@@ -139,19 +144,25 @@ func newStatsCollector() statsCollector {
 			typ: m.typ,
 		}
 	}
-	return smetrics
+	return statsCollector{
+		metrics: smetrics,
+		auto:    newAutoMetrics(systemNode, nil, StatsMetrics, opts),
+	}
 }
 
 func (sc statsCollector) describe(ch chan<- *prometheus.Desc) {
-	for _, s := range sc {
+	for _, s := range sc.metrics {
 		ch <- s.desc
 	}
 }
 
-func (sc statsCollector) collect(conn *as.Connection) ([]prometheus.Metric, error) {
-	res, err := as.RequestInfo(conn, "statistics")
+func (sc statsCollector) collect(client infoClient) ([]prometheus.Metric, error) {
+	res, err := client.RequestInfo("statistics")
 	if err != nil {
 		return nil, err
 	}
-	return infoCollect(cmetrics(sc), res["statistics"]), nil
+	return append(
+		infoCollect(sc.metrics, res["statistics"]),
+		sc.auto.collect(sc.metrics, res["statistics"])...,
+	), nil
 }
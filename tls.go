@@ -0,0 +1,65 @@
+package main
+
+import (
+	"crypto/tls"
+	"crypto/x509"
+	"fmt"
+	"io/ioutil"
+)
+
+var tlsVersions = map[string]uint16{
+	"1.0": tls.VersionTLS10,
+	"1.1": tls.VersionTLS11,
+	"1.2": tls.VersionTLS12,
+	"1.3": tls.VersionTLS13,
+}
+
+// tlsOptions are the settings to connect to nodes over TLS.
+type tlsOptions struct {
+	enabled    bool // TLS with the system CAs, if there are no files
+	caFile     string
+	certFile   string
+	keyFile    string
+	minVersion string
+}
+
+// config makes a *tls.Config. Returns nil if no TLS is configured. TLS is
+// enabled explicitly, or by any of the files.
+func (o tlsOptions) config() (*tls.Config, error) {
+	if !o.enabled && o.caFile == "" && o.certFile == "" && o.keyFile == "" {
+		if o.minVersion != "" {
+			return nil, fmt.Errorf("min_version without TLS")
+		}
+		return nil, nil
+	}
+
+	cfg := &tls.Config{}
+	if o.minVersion != "" {
+		v, ok := tlsVersions[o.minVersion]
+		if !ok {
+			return nil, fmt.Errorf("unknown TLS version %q", o.minVersion)
+		}
+		cfg.MinVersion = v
+	}
+
+	if o.caFile != "" {
+		pem, err := ioutil.ReadFile(o.caFile)
+		if err != nil {
+			return nil, err
+		}
+		pool := x509.NewCertPool()
+		if !pool.AppendCertsFromPEM(pem) {
+			return nil, fmt.Errorf("%s: no certificates found", o.caFile)
+		}
+		cfg.RootCAs = pool
+	}
+
+	if o.certFile != "" || o.keyFile != "" {
+		cert, err := tls.LoadX509KeyPair(o.certFile, o.keyFile)
+		if err != nil {
+			return nil, err
+		}
+		cfg.Certificates = []tls.Certificate{cert}
+	}
+	return cfg, nil
+}
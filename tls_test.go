@@ -0,0 +1,50 @@
+package main
+
+import (
+	"testing"
+)
+
+func TestTLSOptions(t *testing.T) {
+	cfg, err := tlsOptions{}.config()
+	if err != nil {
+		t.Fatal(err)
+	}
+	if cfg != nil {
+		t.Errorf("have %v, want nil", cfg)
+	}
+
+	type cas struct {
+		opts  tlsOptions
+		error string
+	}
+	for _, c := range []cas{
+		{
+			opts:  tlsOptions{caFile: "/no/such/ca.pem", minVersion: "1.2"},
+			error: "open /no/such/ca.pem: no such file or directory",
+		},
+		{
+			opts:  tlsOptions{caFile: "/no/such/ca.pem", minVersion: "1.4"},
+			error: `unknown TLS version "1.4"`,
+		},
+		{
+			opts:  tlsOptions{certFile: "/no/such/cert.pem"},
+			error: "open /no/such/cert.pem: no such file or directory",
+		},
+		{
+			opts:  tlsOptions{minVersion: "1.2"},
+			error: "min_version without TLS",
+		},
+		{
+			opts: tlsOptions{enabled: true, minVersion: "1.2"},
+		},
+	} {
+		_, err := c.opts.config()
+		haveerr := ""
+		if err != nil {
+			haveerr = err.Error()
+		}
+		if have, want := haveerr, c.error; have != want {
+			t.Errorf("have %q, want %q", have, want)
+		}
+	}
+}
@@ -0,0 +1,40 @@
+package main
+
+import (
+	"fmt"
+	"strconv"
+	"strings"
+)
+
+// buildVersion is an Aerospike server version, as reported by the build command.
+// e.g. 5.1.0.3 is buildVersion{5, 1, 0, 3}.
+type buildVersion []int
+
+func parseVersion(s string) (buildVersion, error) {
+	// there might be a suffix, like 4.9.0.11-rc1
+	s = strings.SplitN(strings.TrimSpace(s), "-", 2)[0]
+	var v buildVersion
+	for _, p := range strings.Split(s, ".") {
+		i, err := strconv.Atoi(p)
+		if err != nil {
+			return nil, fmt.Errorf("invalid version %q", s)
+		}
+		v = append(v, i)
+	}
+	return v, nil
+}
+
+// atLeast compares with a (partial) version. atLeast(5, 1) is true for
+// 5.1.0.3.
+func (v buildVersion) atLeast(o ...int) bool {
+	for i, p := range o {
+		var vp int
+		if i < len(v) {
+			vp = v[i]
+		}
+		if vp != p {
+			return vp > p
+		}
+	}
+	return true
+}
@@ -0,0 +1,53 @@
+package main
+
+import (
+	"reflect"
+	"testing"
+)
+
+func TestParseVersion(t *testing.T) {
+	type cas struct {
+		build string
+		error string
+		want  buildVersion
+	}
+	for _, c := range []cas{
+		{build: "3.15.1.4", want: buildVersion{3, 15, 1, 4}},
+		{build: "5.1.0.3\n", want: buildVersion{5, 1, 0, 3}},
+		{build: "4.9.0.11-rc1", want: buildVersion{4, 9, 0, 11}},
+		{build: "ERROR::unknown", error: `invalid version "ERROR::unknown"`},
+		{build: "", error: `invalid version ""`},
+	} {
+		v, err := parseVersion(c.build)
+		haveerr := ""
+		if err != nil {
+			haveerr = err.Error()
+		}
+		if have, want := haveerr, c.error; have != want {
+			t.Errorf("have %q, want %q", have, want)
+			continue
+		}
+		if have, want := v, c.want; !reflect.DeepEqual(have, want) {
+			t.Errorf("have %+v, want %+v", have, want)
+		}
+	}
+}
+
+func TestVersionAtLeast(t *testing.T) {
+	v := buildVersion{5, 1, 0, 3}
+	for _, c := range []struct {
+		o    []int
+		want bool
+	}{
+		{[]int{5, 1}, true},
+		{[]int{5, 1, 0, 3}, true},
+		{[]int{5, 1, 0, 4}, false},
+		{[]int{5, 2}, false},
+		{[]int{4, 9}, true},
+		{[]int{6}, false},
+	} {
+		if have, want := v.atLeast(c.o...), c.want; have != want {
+			t.Errorf("%v: have %t, want %t", c.o, have, want)
+		}
+	}
+}
@@ -3,7 +3,6 @@ package main
 import (
   "strings"
 
-  as "github.com/aerospike/aerospike-client-go"
   "github.com/prometheus/client_golang/prometheus"
 )
 
@@ -31,11 +30,18 @@ var (
   }
 )
 
-type XdrDCCollector cmetrics
+type XdrDCCollector struct {
+  metrics cmetrics
+  auto    *autoMetrics
+  dcs     *nameFilter
+}
 
-func newXdrDCCollector() XdrDCCollector {
+func newXdrDCCollector(opts collectorOpts) XdrDCCollector {
   dc := map[string]cmetric {}
   for _, m := range DCMetrics {
+    if !opts.metrics.allow(promkey(xdrDC, m.aeroName)) {
+      continue
+    }
     dc[m.aeroName] = cmetric{
       typ: m.typ,
       desc: prometheus.NewDesc(
@@ -46,36 +52,84 @@ func newXdrDCCollector() XdrDCCollector {
       ),
     }
   }
-  return dc
+  return XdrDCCollector{
+    metrics: dc,
+    auto:    newAutoMetrics(xdrDC, []string{"dc"}, DCMetrics, opts),
+    dcs:     opts.dcs,
+  }
 }
 
 func (dcc XdrDCCollector) describe(ch chan<- *prometheus.Desc) {
-  for _, s := range dcc {
+  for _, s := range dcc.metrics {
     ch <- s.desc
   }
 }
 
-func (sic XdrDCCollector) collect(conn *as.Connection) ([]prometheus.Metric, error) {
-  info, err := as.RequestInfo(conn, "dcs")
+func (sic XdrDCCollector) collect(client infoClient) ([]prometheus.Metric, error) {
+  info, err := client.RequestInfo("dcs")
   if err != nil {
     return nil, err
   }
 
-  var metrics []prometheus.Metric
+  var dcs, cmds []string
   for _, dc := range strings.Split(info["dcs"], ";") {
-    dcInfo, err := as.RequestInfo(conn, "dc/"+dc)
-    if err != nil {
-      return nil, err
+    if !sic.dcs.allow(dc) {
+      continue
     }
+    dcs = append(dcs, dc)
+    cmds = append(cmds, "dc/"+dc)
+  }
+  dcInfo, err := client.RequestInfo(cmds...)
+  if err != nil {
+    return nil, err
+  }
+
+  var metrics []prometheus.Metric
+  for _, dc := range dcs {
 
     metrics = append(
       metrics,
-      infoCollect(
-        cmetrics(sic),
-        dcInfo["dc/"+dc],
-        dc,
-      )...,
+      infoCollect(sic.metrics, dcInfo["dc/"+dc], dc)...,
+    )
+    metrics = append(
+      metrics,
+      sic.auto.collect(sic.metrics, dcInfo["dc/"+dc], dc)...,
     )
   }
   return metrics, nil
 }
+
+// xdrCollector picks the XDR collector for the server version. XDR was
+// rewritten in Aerospike 5.0, and the dcs and dc/<dc> commands were removed.
+type xdrCollector struct {
+  v4 XdrDCCollector
+  v5 xdr5Collector
+}
+
+func newXdrCollector(opts collectorOpts) xdrCollector {
+  return xdrCollector{
+    v4: newXdrDCCollector(opts),
+    v5: newXdr5Collector(opts),
+  }
+}
+
+func (xc xdrCollector) describe(ch chan<- *prometheus.Desc) {
+  xc.v4.describe(ch)
+  xc.v5.describe(ch)
+}
+
+func (xc xdrCollector) collect(client infoClient) ([]prometheus.Metric, error) {
+  // the first stage of both collectors goes with build
+  info, err := client.RequestInfo("build", "dcs", "get-config:context=xdr")
+  if err != nil {
+    return nil, err
+  }
+  v, err := parseVersion(info["build"])
+  if err != nil {
+    return nil, err
+  }
+  if v.atLeast(5) {
+    return xc.v5.collect(prefetchClient{client, info})
+  }
+  return xc.v4.collect(prefetchClient{client, info})
+}
@@ -0,0 +1,141 @@
+package main
+
+import (
+	"strings"
+
+	"github.com/prometheus/client_golang/prometheus"
+)
+
+const xdr5DCNamespace = "xdr_ns"
+
+var (
+	// Xdr5Metrics lists the keys we report from the XDR stats in Aerospike
+	// 5.0+. They are reported per DC, and per DC and namespace.
+	// See `asinfo -v 'get-stats:context=xdr;dc=<dc>'`.
+	Xdr5Metrics = []metric{
+		gauge("lag", "Time in seconds since the oldest record not yet shipped was written."),
+		gauge("in_queue", "Number of records in the queue to be shipped."),
+		gauge("in_progress", "Number of records which are being shipped."),
+		counter("success", "Number of records successfully shipped."),
+		counter("abandoned", "Number of records abandoned."),
+		counter("not_found", "Number of records which were deleted before they were shipped."),
+		counter("filtered_out", "Number of records filtered out."),
+		counter("retry_conn_reset", "Number of retries because of connection resets."),
+		counter("recoveries", "Number of times the XDR in-memory queue was recovered."),
+		gauge("throughput", "Number of records shipped per second."),
+	}
+)
+
+// xdr5Collector collects the XDR stats of Aerospike 5.0+.
+type xdr5Collector struct {
+	dcMetrics  cmetrics
+	nsMetrics  cmetrics
+	dcAuto     *autoMetrics
+	nsAuto     *autoMetrics
+	dcs        *nameFilter
+	namespaces *nameFilter
+}
+
+func newXdr5Collector(opts collectorOpts) xdr5Collector {
+	mk := func(sys string, labels []string) cmetrics {
+		ms := cmetrics{}
+		for _, m := range Xdr5Metrics {
+			if !opts.metrics.allow(promkey(sys, m.aeroName)) {
+				continue
+			}
+			ms[m.aeroName] = cmetric{
+				typ: m.typ,
+				desc: prometheus.NewDesc(
+					promkey(sys, m.aeroName),
+					m.desc,
+					labels,
+					nil,
+				),
+			}
+		}
+		return ms
+	}
+	return xdr5Collector{
+		dcMetrics:  mk(xdrDC, []string{"dc"}),
+		nsMetrics:  mk(xdr5DCNamespace, []string{"dc", "namespace"}),
+		dcAuto:     newAutoMetrics(xdrDC, []string{"dc"}, Xdr5Metrics, opts),
+		nsAuto:     newAutoMetrics(xdr5DCNamespace, []string{"dc", "namespace"}, Xdr5Metrics, opts),
+		dcs:        opts.dcs,
+		namespaces: opts.namespaces,
+	}
+}
+
+func (xc xdr5Collector) describe(ch chan<- *prometheus.Desc) {
+	for _, s := range xc.dcMetrics {
+		ch <- s.desc
+	}
+	for _, s := range xc.nsMetrics {
+		ch <- s.desc
+	}
+}
+
+func (xc xdr5Collector) collect(client infoClient) ([]prometheus.Metric, error) {
+	info, err := client.RequestInfo("get-config:context=xdr")
+	if err != nil {
+		return nil, err
+	}
+	var (
+		dcs  []string
+		cmds []string
+	)
+	for _, dc := range infoList(info["get-config:context=xdr"], "dcs") {
+		if !xc.dcs.allow(dc) {
+			continue
+		}
+		dcs = append(dcs, dc)
+		cmds = append(cmds, "get-config:context=xdr;dc="+dc, "get-stats:context=xdr;dc="+dc)
+	}
+	if len(cmds) == 0 {
+		return nil, nil
+	}
+	dcInfo, err := client.RequestInfo(cmds...)
+	if err != nil {
+		return nil, err
+	}
+
+	var metrics []prometheus.Metric
+	cmds = cmds[:0]
+	for _, dc := range dcs {
+		stats := dcInfo["get-stats:context=xdr;dc="+dc]
+		metrics = append(metrics, infoCollect(xc.dcMetrics, stats, dc)...)
+		metrics = append(metrics, xc.dcAuto.collect(xc.dcMetrics, stats, dc)...)
+
+		for _, ns := range infoList(dcInfo["get-config:context=xdr;dc="+dc], "namespaces") {
+			if !xc.namespaces.allow(ns) {
+				continue
+			}
+			cmds = append(cmds, "get-stats:context=xdr;dc="+dc+";namespace="+ns)
+		}
+	}
+	if len(cmds) == 0 {
+		return metrics, nil
+	}
+	nsInfo, err := client.RequestInfo(cmds...)
+	if err != nil {
+		return nil, err
+	}
+	for _, cmd := range cmds {
+		// get-stats:context=xdr;dc=<dc>;namespace=<ns>
+		kv := parseInfo(cmd)
+		dc, ns := kv["dc"], kv["namespace"]
+		metrics = append(metrics, infoCollect(xc.nsMetrics, nsInfo[cmd], dc, ns)...)
+		metrics = append(metrics, xc.nsAuto.collect(xc.nsMetrics, nsInfo[cmd], dc, ns)...)
+	}
+	return metrics, nil
+}
+
+// infoList returns the comma separated values of key in an info response.
+func infoList(info, key string) []string {
+	var res []string
+	for _, v := range strings.Split(parseInfo(info)[key], ",") {
+		if v != "" {
+			res = append(res, v)
+		}
+	}
+	return res
+}
@@ -0,0 +1,40 @@
+package main
+
+import (
+	"reflect"
+	"testing"
+)
+
+func TestInfoList(t *testing.T) {
+	type cas struct {
+		info string
+		key  string
+		want []string
+	}
+	for n, c := range []cas{
+		{
+			info: "dcs=DC1,DC2:src-id=0:trace-sample=0",
+			key:  "dcs",
+			want: []string{"DC1", "DC2"},
+		},
+		{
+			info: "dcs=:src-id=0",
+			key:  "dcs",
+			want: nil,
+		},
+		{
+			info: "auth-mode=none:namespaces=test:period-ms=100",
+			key:  "namespaces",
+			want: []string{"test"},
+		},
+		{
+			info: "",
+			key:  "namespaces",
+			want: nil,
+		},
+	} {
+		if have, want := infoList(c.info, c.key), c.want; !reflect.DeepEqual(have, want) {
+			t.Errorf("case %d: have %+v, want %+v", n, have, want)
+		}
+	}
+}